@@ -1,34 +1,105 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
 	"database/sql"
+	"embed"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
+	"net/smtp"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+	"unicode"
 
+	"github.com/XSAM/otelsql"
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
-// loggingMiddleware wraps handlers to add request logging
+// loggingMiddleware wraps handlers to add request logging. Each request gets an ID,
+// taken from X-Request-ID when the client sends a usable one, which is echoed back
+// in the response and attached to every log line written with the request context.
 func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+
+		requestID := r.Header.Get(requestIDHeader)
+		if !isValidRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, requestID)
+
 		rw := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
-		next(rw, r)
+		next(rw, r.WithContext(ctx))
 		duration := time.Since(start)
-		slog.Info("HTTP request",
+
+		// Label by route pattern rather than raw path to keep cardinality bounded
+		route := r.Pattern
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestsTotal.WithLabelValues(route, strconv.Itoa(rw.statusCode)).Inc()
+		httpRequestDuration.WithLabelValues(route).Observe(duration.Seconds())
+
+		// The tracing handler runs before the mux, so the span only gets its
+		// route once the request has been matched
+		if span := trace.SpanFromContext(ctx); span.IsRecording() {
+			method, path, ok := strings.Cut(route, " ")
+			if !ok {
+				method, path = r.Method, route
+			}
+			span.SetName(method + " " + path)
+			span.SetAttributes(attribute.String("http.route", path))
+		}
+
+		slog.InfoContext(ctx, "HTTP request",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"status", rw.statusCode,
@@ -38,6 +109,130 @@ func loggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// Header used to pass request IDs in and out
+const requestIDHeader = "X-Request-ID"
+
+// Longest incoming request ID that's accepted rather than replaced
+const maxRequestIDLength = 128
+
+// requestIDKey is the context key holding the current request's ID
+type requestIDKey struct{}
+
+// requestIDFromContext returns the request ID stored by loggingMiddleware, or ""
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID returns a random 16 character hex ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestID reports whether a client-supplied ID is safe to log and echo back
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDHandler is a slog.Handler that adds the request ID from the context,
+// if any, to each record
+type requestIDHandler struct {
+	slog.Handler
+}
+
+func (h requestIDHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h requestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestIDHandler) WithGroup(name string) slog.Handler {
+	return requestIDHandler{h.Handler.WithGroup(name)}
+}
+
+// authMiddleware requires HTTP basic auth when AUTH_USER and AUTH_PASS are configured
+func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if authUser == "" || authPass == "" {
+			next(w, r)
+			return
+		}
+
+		user, pass, ok := r.BasicAuth()
+		if !ok || !secureCompare(user, authUser) || !secureCompare(pass, authPass) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hn-reader", charset="UTF-8"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// apiAuthMiddleware guards the JSON API and endpoints that change data. When
+// API_TOKEN is set it requires "Authorization: Bearer <token>", though browsers
+// signed in with basic auth are still let through so the UI keeps working.
+// Without a token it's the same as authMiddleware.
+func apiAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if apiToken == "" {
+			authMiddleware(next)(w, r)
+			return
+		}
+
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if secureCompare(token, apiToken) {
+				next(w, r)
+				return
+			}
+		} else if user, pass, ok := r.BasicAuth(); ok && authUser != "" && authPass != "" &&
+			secureCompare(user, authUser) && secureCompare(pass, authPass) {
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", `Bearer realm="hn-reader"`)
+		writeJSONError(w, http.StatusUnauthorized, "Missing or invalid API token")
+	}
+}
+
+// APIError is the body of every error response from the JSON API
+type APIError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// writeJSONError responds with status and a JSON error body
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(APIError{Error: msg, Status: status})
+}
+
+// secureCompare compares two strings in constant time. Hashing first keeps the
+// comparison from leaking the length of the expected value.
+func secureCompare(given, expected string) bool {
+	a := sha256.Sum256([]byte(given))
+	b := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
 	http.ResponseWriter
@@ -49,556 +244,6948 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// RSS Feed structures
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// extend the write deadline
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// Responses smaller than this aren't worth compressing
+const gzipMinSize = 1024
+
+// What cross-origin callers of the API may send and read
+const (
+	corsAllowMethods  = "GET, POST, DELETE, OPTIONS"
+	corsAllowHeaders  = "Authorization, Content-Type, If-None-Match"
+	corsExposeHeaders = "ETag, Retry-After, X-Request-ID"
+	corsMaxAge        = "600"
+)
+
+// corsMiddleware lets pages on the given origins call /api/* from the browser,
+// with "*" allowing any origin. Preflight requests are answered here, ahead of
+// auth, since browsers send them without credentials. Other paths and origins
+// get no CORS headers, so the browser keeps them same-origin.
+func corsMiddleware(origins []string, next http.Handler) http.Handler {
+	allowAny := slices.Contains(origins, "*")
+	// Origin headers are lowercase with no trailing slash
+	origins = slices.Clone(origins)
+	for i, origin := range origins {
+		origins[i] = strings.TrimSuffix(strings.ToLower(origin), "/")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !allowAny && !slices.Contains(origins, strings.ToLower(origin)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowAny {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", corsAllowMethods)
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip. The start of
+// the body is buffered so small responses can go out uncompressed.
+func gzipMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		defer gw.Close()
+		next(gw, r)
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the status and the first gzipMinSize bytes,
+// then either compresses the rest or passes it straight through
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	statusCode int
+	buf        []byte
+	started    bool
+	gz         *gzip.Writer
+}
+
+func (gw *gzipResponseWriter) WriteHeader(code int) {
+	if !gw.started {
+		gw.statusCode = code
+	}
+}
+
+func (gw *gzipResponseWriter) Write(p []byte) (int, error) {
+	if !gw.started {
+		gw.buf = append(gw.buf, p...)
+		if len(gw.buf) < gzipMinSize {
+			return len(p), nil
+		}
+		if err := gw.start(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if gw.gz != nil {
+		return gw.gz.Write(p)
+	}
+	return gw.ResponseWriter.Write(p)
+}
+
+// start sends the headers and anything buffered, compressing when asked to and
+// the handler hasn't already encoded the body itself
+func (gw *gzipResponseWriter) start(compress bool) error {
+	gw.started = true
+	h := gw.Header()
+	// Sniff before compressing, net/http would otherwise sniff the gzip bytes
+	if h.Get("Content-Type") == "" && len(gw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	// Compressed bytes differ from the original, so a strong validator no longer
+	// applies. 304s answer a client that got the compressed copy too.
+	if gw.gz != nil || gw.statusCode == http.StatusNotModified {
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	gw.ResponseWriter.WriteHeader(gw.statusCode)
+
+	if len(gw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf)
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf)
+	}
+	gw.buf = nil
+	return err
+}
+
+// Close sends a response that stayed under gzipMinSize and finishes the gzip stream
+func (gw *gzipResponseWriter) Close() error {
+	if !gw.started {
+		return gw.start(false)
+	}
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
+
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
+// RSS Feed structures, used both to parse incoming feeds and to render /feed.xml
 type RSS struct {
-	Channel Channel `xml:"channel"`
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr,omitempty"`
+	Channel Channel  `xml:"channel"`
 }
 
 type Channel struct {
-	Items []Item `xml:"item"`
+	Title         string `xml:"title,omitempty"`
+	Link          string `xml:"link,omitempty"`
+	Description   string `xml:"description,omitempty"`
+	LastBuildDate string `xml:"lastBuildDate,omitempty"`
+	Items         []Item `xml:"item"`
 }
 
 type Item struct {
 	Title       string `xml:"title"`
 	Link        string `xml:"link"`
+	GUID        string `xml:"guid,omitempty"`
 	PubDate     string `xml:"pubDate"`
 	Description string `xml:"description"`
+	Comments    string `xml:"comments,omitempty"`
+}
+
+// JSON Feed 1.1 structures for rendering /feed.json, see https://jsonfeed.org/version/1.1
+type JSONFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url,omitempty"`
+	FeedURL     string         `json:"feed_url,omitempty"`
+	Description string         `json:"description,omitempty"`
+	Items       []JSONFeedItem `json:"items"`
+}
+
+type JSONFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url,omitempty"`
+	Title         string `json:"title,omitempty"`
+	ContentHTML   string `json:"content_html"`
+	DatePublished string `json:"date_published,omitempty"`
+}
+
+// OPML structures for exporting the configured feeds
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    OPMLHead `xml:"head"`
+	Body    OPMLBody `xml:"body"`
+}
+
+type OPMLHead struct {
+	Title       string `xml:"title"`
+	DateCreated string `xml:"dateCreated"`
+}
+
+type OPMLBody struct {
+	Outlines []OPMLOutline `xml:"outline"`
+}
+
+type OPMLOutline struct {
+	Type   string `xml:"type,attr"`
+	Text   string `xml:"text,attr"`
+	Title  string `xml:"title,attr"`
+	XMLURL string `xml:"xmlUrl,attr"`
 }
 
 // Article represents a Hacker News article
 type Article struct {
-	ID          int
-	Date        string
-	ArticleLink string
-	CommentLink string
-	Title       string
-	CreatedAt   time.Time
-	Read        bool
+	ID             int        `json:"id"`
+	Date           string     `json:"date"`
+	ArticleLink    string     `json:"article_link"`
+	CommentLink    string     `json:"comment_link"`
+	Title          string     `json:"title"`
+	Source         string     `json:"source"`
+	Points         int        `json:"points"`
+	CommentCount   int        `json:"comment_count"`
+	CreatedAt      time.Time  `json:"created_at"`
+	Read           bool       `json:"read"`
+	ReadAt         *time.Time `json:"read_at"`
+	Status         string     `json:"status"`
+	Host           string     `json:"host"`
+	Starred        bool       `json:"starred"`
+	Tags           []string   `json:"tags"`
+	ReadingMinutes int        `json:"reading_minutes,omitempty"` // 0 when no readable content was saved
+	SnoozedUntil   *time.Time `json:"snoozed_until,omitempty"`
+
+	// The feed item the article was parsed from, as JSON, and its row in
+	// raw_items when raw items are stored, so it can be parsed again after parser fixes
+	rawItem   string
+	rawItemID int
+}
+
+// ReaderData holds data for the reader view of a single article
+type ReaderData struct {
+	SiteTitle  string
+	Article    Article
+	Paragraphs []string
 }
 
 // TemplateData holds data to pass to templates
 type TemplateData struct {
 	Title        string
+	Subtitle     string
 	LastSyncTime time.Time
 	Articles     []Article
+	UnreadCount  int
+	Show         string
+	Sort         string
+	From         string
+	To           string
+	Host         string
+	ExcludeHost  string
+	Page         int
+	PerPage      int
+	TotalPages   int
+}
+
+// ListURL returns a home page URL for the given show filter and page, keeping other settings
+func (d TemplateData) ListURL(show string, page int) string {
+	params := url.Values{}
+	params.Set("show", show)
+	params.Set("sort", d.Sort)
+	if d.From != "" {
+		params.Set("from", d.From)
+	}
+	if d.To != "" {
+		params.Set("to", d.To)
+	}
+	if d.Host != "" {
+		params.Set("host", d.Host)
+	}
+	if d.ExcludeHost != "" {
+		params.Set("exclude_host", d.ExcludeHost)
+	}
+	params.Set("page", strconv.Itoa(page))
+	params.Set("per_page", strconv.Itoa(d.PerPage))
+	return "/?" + params.Encode()
+}
+
+// SortURL returns a home page URL for the first page in the given sort order, keeping other settings
+func (d TemplateData) SortURL(sort string) string {
+	d.Sort = sort
+	return d.ListURL(d.Show, 1)
+}
+
+// HostURL returns a home page URL for the first page of articles from host, keeping other settings
+func (d TemplateData) HostURL(host string) string {
+	d.Host, d.ExcludeHost = host, ""
+	return d.ListURL(d.Show, 1)
+}
+
+// ClearHostURL returns a home page URL for the first page without the host filters
+func (d TemplateData) ClearHostURL() string {
+	d.Host, d.ExcludeHost = "", ""
+	return d.ListURL(d.Show, 1)
+}
+
+// HasPrev reports whether there is a page before the current one
+func (d TemplateData) HasPrev() bool {
+	return d.Page > 1
+}
+
+// HasNext reports whether there is a page after the current one
+func (d TemplateData) HasNext() bool {
+	return d.Page < d.TotalPages
+}
+
+// PrevPage returns the previous page number
+func (d TemplateData) PrevPage() int {
+	return d.Page - 1
+}
+
+// NextPage returns the next page number
+func (d TemplateData) NextPage() int {
+	return d.Page + 1
 }
 
-// Database global
+// sqliteTimeFormat matches the format SQLite uses for CURRENT_TIMESTAMP, so
+// timestamps written from Go sort correctly alongside ones written by SQLite
+const sqliteTimeFormat = "2006-01-02 15:04:05"
+
+// Pagination defaults and limits
+const (
+	defaultPerPage = 50
+	maxPerPage     = 200
+	maxPage        = 100000
+)
+
+// Default window for /recently-read, in minutes
+const defaultRecentlyReadMinutes = 10
+
+// Maximum number of results returned by a search
+const maxSearchResults = 50
+
+// Database connection pool. Queries go through store, this is only for managing connections
 var db *sql.DB
 
+// Rendered home pages keyed by their query, cleared whenever articles change.
+// The generation lets a render that raced with a change avoid caching stale HTML.
+var (
+	homeCacheEnabled    = true
+	homeCacheMu         sync.RWMutex
+	homeCache           = map[string]cachedPage{}
+	homeCacheGeneration uint64
+)
+
+// Most home page variants cached at once, so odd query strings can't grow it forever
+const maxHomeCacheEntries = 100
+
+// cachedPage is a rendered page and its ETag
+type cachedPage struct {
+	body []byte
+	etag string
+}
+
 // Last sync time with mutex for thread safety
 var (
 	lastSyncTime time.Time
 	syncTimeMu   sync.RWMutex
 )
 
-// Templates holds parsed templates
-var templates *template.Template
+// Prometheus metrics
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "hn_reader_http_requests_total",
+		Help: "Total HTTP requests by route and status code.",
+	}, []string{"path", "status"})
 
-// HTTP client with timeout
-var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
-}
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hn_reader_http_request_duration_seconds",
+		Help:    "HTTP request duration by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"path"})
 
-// initDB initializes the SQLite database
-func initDB() error {
-	// Create db directory if it doesn't exist
-	if err := os.MkdirAll("db", 0755); err != nil {
-		return fmt.Errorf("failed to create db directory: %w", err)
-	}
+	feedSyncsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hn_reader_feed_syncs_total",
+		Help: "Total feed syncs started.",
+	})
 
-	var err error
-	db, err = sql.Open("sqlite3", "./db/hn_reader.db")
-	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
-	}
+	feedSyncFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hn_reader_feed_sync_failures_total",
+		Help: "Total feeds that failed to sync.",
+	})
 
-	// Set connection pool limits for thread safety
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	articlesInsertedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "hn_reader_articles_inserted_total",
+		Help: "Total new articles inserted by feed syncs.",
+	})
 
-	// Create articles table
-	createTableSQL := `CREATE TABLE IF NOT EXISTS articles (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		date TEXT NOT NULL,
-		article_link TEXT NOT NULL,
-		comment_link TEXT NOT NULL,
-		title TEXT NOT NULL,
-		read INTEGER DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(article_link, comment_link)
-	);`
+	lastSyncNewArticles = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "hn_reader_last_sync_new_articles",
+		Help: "New articles inserted by the most recent feed sync.",
+	})
 
-	_, err = db.Exec(createTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create table: %w", err)
-	}
+	unreadArticlesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "hn_reader_unread_articles",
+		Help: "Current number of unread articles.",
+	})
 
-	slog.Info("Database initialized successfully")
-	return nil
-}
+	dbUpGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "hn_reader_db_up",
+		Help: "Whether the last database health check succeeded (1) or failed (0).",
+	})
+)
 
-// loadTemplates loads all HTML templates
-func loadTemplates() error {
-	var err error
-	templates, err = template.ParseGlob(filepath.Join("templates", "*.html"))
-	if err != nil {
-		return fmt.Errorf("failed to load templates: %w", err)
+// Tracing is on when OTEL_EXPORTER_OTLP_ENDPOINT is set. Until then tracer hands
+// out no-op spans, so instrumented code costs next to nothing.
+var (
+	tracingEnabled bool
+	tracer         = otel.Tracer("hn-reader")
+)
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT is
+// set. The exporter also reads the other standard OTEL_* variables, such as
+// OTEL_EXPORTER_OTLP_HEADERS and OTEL_SERVICE_NAME. The returned function flushes
+// any buffered spans and must be called on shutdown.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
 	}
-	slog.Info("Templates loaded successfully")
-	return nil
-}
 
-// fetchAndParseRSS fetches the RSS feed and parses it
-func fetchAndParseRSS() (*RSS, error) {
-	resp, err := httpClient.Get("https://www.daemonology.net/hn-daily/index.rss")
+	exporter, err := otlptracehttp.New(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch RSS: %w", err)
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
+	// Later options win, so OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			attribute.String("service.name", "hn-reader"),
+			attribute.String("service.version", version),
+		),
+		resource.WithFromEnv(),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read RSS body: %w", err)
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
 	}
 
-	var rss RSS
-	err = xml.Unmarshal(body, &rss)
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	// Continue traces started by whoever called us
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	tracingEnabled = true
+	return provider.Shutdown, nil
+}
+
+// endSpan marks span as failed when err is set, then ends it
+func endSpan(span trace.Span, err error) {
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse RSS: %w", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.End()
+}
 
-	slog.Info("Successfully fetched RSS feed", "items", len(rss.Channel.Items))
-	return &rss, nil
+// Maximum time the health check waits on the database
+const healthCheckTimeout = 2 * time.Second
+
+// How often the database is checked in the background, and the backoff between
+// checks while it's down
+const (
+	dbMonitorInterval    = 30 * time.Second
+	dbReconnectBaseDelay = 1 * time.Second
+)
+
+// dbHealth is the database state last seen by a health check
+var dbHealth struct {
+	mu      sync.Mutex
+	healthy bool
+	since   time.Time
+	lastErr string
 }
 
-// parseArticlesFromDescription extracts article links from the CDATA description
-func parseArticlesFromDescription(description, date string) []Article {
-	var articles []Article
+// How often the unread gauge is refreshed outside of syncs
+const unreadGaugeInterval = 1 * time.Minute
 
-	// Split by <li> tags
-	lines := strings.Split(description, "<li>")
+// How often snoozes that have run out are cleared, so cached pages pick the
+// articles up again
+const snoozeCheckInterval = 1 * time.Minute
 
-	for _, line := range lines {
-		if !strings.Contains(line, "storylink") {
-			continue
-		}
+// Basic auth credentials, auth is disabled unless both are set
+var authUser, authPass string
 
-		var articleLink, commentLink, title string
+// Bearer token for the API and mutating endpoints, token auth is disabled when empty
+var apiToken string
 
-		// Extract article link and title
-		if idx := strings.Index(line, `<span class="storylink"><a href="`); idx != -1 {
-			start := idx + len(`<span class="storylink"><a href="`)
-			end := strings.Index(line[start:], `"`)
-			if end != -1 {
-				articleLink = line[start : start+end]
-			}
+// Used by work that isn't tied to a request, like scheduled syncs and pruning.
+// It's cancelled when a shutdown signal is received.
+var backgroundCtx, cancelBackground = context.WithCancel(context.Background())
 
-			// Extract title
-			titleStart := strings.Index(line[start+end:], `">`) + start + end + 2
-			titleEnd := strings.Index(line[titleStart:], "</a>")
-			if titleEnd != -1 {
-				title = line[titleStart : titleStart+titleEnd]
-			}
-		}
+// Tracks goroutines started with runInBackground so shutdown can wait for them
+var backgroundWG sync.WaitGroup
 
-		// Extract comment link
-		if idx := strings.Index(line, `<span class="postlink"><a href="`); idx != -1 {
-			start := idx + len(`<span class="postlink"><a href="`)
-			end := strings.Index(line[start:], `"`)
-			if end != -1 {
-				commentLink = line[start : start+end]
-			}
-		}
+// How long shutdown waits for requests and then background work to finish
+const shutdownTimeout = 30 * time.Second
 
-		if articleLink != "" && commentLink != "" && title != "" {
-			articles = append(articles, Article{
-				Date:        date,
-				ArticleLink: articleLink,
-				CommentLink: commentLink,
-				Title:       title,
-			})
+// runInBackground runs f in a goroutine that shutdown waits for. Long-running
+// work should use backgroundCtx so it stops promptly once shutdown begins.
+func runInBackground(f func()) {
+	backgroundWG.Add(1)
+	go func() {
+		defer backgroundWG.Done()
+		f()
+	}()
+}
+
+// waitForBackground waits for background goroutines to finish, returning false
+// if ctx expires first
+func waitForBackground(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		backgroundWG.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Set while a feed sync is running so syncs never overlap
+var syncRunning atomic.Bool
+
+// Templates holds parsed templates, use currentTemplates to read it
+var (
+	templatesMu sync.RWMutex
+	templates   *template.Template
+)
+
+// Set from DEV. Templates are re-parsed on every render and the page cache is
+// off, so template edits show up without restarting.
+var devMode bool
+
+// Templates and static files are compiled into the binary so it runs on its own
+//
+//go:embed static templates
+var embeddedAssets embed.FS
+
+// Where templates and static files are read from, the embedded copies unless
+// ASSETS_DIR points at a directory on disk
+var assets fs.FS = embeddedAssets
+
+// missingAssetDirs returns the subdirectories an assets dir needs but doesn't
+// have, counting a templates dir with no templates in it as missing. The
+// embedded copies are used instead of a dir that's missing any, so a checkout
+// run from the wrong directory still starts.
+func missingAssetDirs(assetsDir string) []string {
+	var missing []string
+	for _, dir := range []string{"templates", "static"} {
+		if info, err := os.Stat(filepath.Join(assetsDir, dir)); err != nil || !info.IsDir() {
+			missing = append(missing, dir)
+		}
+	}
+	if !slices.Contains(missing, "templates") {
+		if matches, _ := filepath.Glob(filepath.Join(assetsDir, "templates", "*.html")); len(matches) == 0 {
+			missing = append(missing, "templates")
 		}
 	}
+	return missing
+}
 
-	return articles
+// Build information, set with -ldflags "-X main.version=... -X main.commit=... -X main.buildDate=..."
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildDate = "unknown"
+)
+
+// VersionInfo is the JSON returned by /version
+type VersionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
 }
 
-// saveArticle saves an article to the database and returns whether it was inserted
-func saveArticle(article Article) (bool, error) {
-	result, err := db.Exec(`
-		INSERT OR IGNORE INTO articles (date, article_link, comment_link, title)
-		VALUES (?, ?, ?, ?)
-	`, article.Date, article.ArticleLink, article.CommentLink, article.Title)
+// User-Agent sent with outbound requests, set from USER_AGENT
+var userAgent = defaultUserAgent()
 
-	if err != nil {
-		return false, fmt.Errorf("failed to save article: %w", err)
-	}
+// defaultUserAgent identifies this build to the servers it fetches from
+func defaultUserAgent() string {
+	return "hn-reader/" + version
+}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return false, fmt.Errorf("failed to get rows affected: %w", err)
+// defaultFeedURL is the feed used when FEED_URL is not set
+const defaultFeedURL = "https://www.daemonology.net/hn-daily/index.rss"
+
+// manualSource is the source recorded for articles added by hand
+const manualSource = "manual"
+
+// Default name shown on pages, feeds and digests, overridden by SITE_TITLE
+const defaultSiteTitle = "HN Reader"
+
+// Branding set from SITE_TITLE and SITE_SUBTITLE
+var (
+	siteTitle    = defaultSiteTitle
+	siteSubtitle string
+)
+
+// Feed URLs to sync from, configured at startup
+var feedURLs = []string{defaultFeedURL}
+
+// HTTP client with timeout
+var httpClient = &http.Client{
+	Timeout: 30 * time.Second,
+}
+
+// Largest feed body read, after decompression, set from MAX_FEED_BYTES
+var maxFeedBytes int64 = 5 << 20
+
+// errFeedNotModified is returned by fetchAndParseRSS when the server answers 304
+var errFeedNotModified = errors.New("feed not modified")
+
+// Retry policy for feed fetches, variables so tests can shorten them
+var (
+	fetchRetryAttempts  = 3
+	fetchRetryBaseDelay = 1 * time.Second
+)
+
+// Article statuses. Read articles always have read = 1 and the others read = 0.
+const (
+	articleStatusNew   = "new"
+	articleStatusLater = "later"
+	articleStatusRead  = "read"
+)
+
+// Prune modes for old read articles
+const (
+	pruneModeArchive = "archive"
+	pruneModeDelete  = "delete"
+)
+
+// How often old read articles are pruned
+const pruneInterval = 24 * time.Hour
+
+// Supported log output formats
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// Values for DEDUP_BY. linkpair treats the same story with a different discussion
+// as a separate article, link collapses them into the first one seen.
+const (
+	dedupByLinkPair = "linkpair"
+	dedupByLink     = "link"
+)
+
+// How new articles are matched against existing ones, set from DEDUP_BY
+var dedupBy = dedupByLinkPair
+
+// Whether articles seen again pick up higher points and comment counts, set from
+// REFRESH_COUNTS. Deduplicating by link always does this.
+var refreshCounts bool
+
+// Values for HIDE_SEEN, what happens to a new article whose title matches one
+// that's already been read
+const (
+	hideSeenOff  = "off"
+	hideSeenRead = "read"
+	hideSeenSkip = "skip"
+)
+
+// Set from HIDE_SEEN
+var hideSeen = hideSeenOff
+
+// Supported database drivers
+const (
+	dbDriverSQLite   = "sqlite"
+	dbDriverPostgres = "postgres"
+)
+
+// Config holds runtime settings. Values come from built-in defaults, then an
+// optional JSON config file, then environment variables, in increasing priority.
+type Config struct {
+	Host             string       `json:"host"`
+	AssetsDir        string       `json:"assets_dir"`
+	Dev              bool         `json:"dev"`
+	Port             string       `json:"port"`
+	FeedURLs         []string     `json:"feed_urls"`
+	RefreshInterval  jsonDuration `json:"refresh_interval"`
+	ReadTimeout      jsonDuration `json:"read_timeout"`
+	WriteTimeout     jsonDuration `json:"write_timeout"`
+	IdleTimeout      jsonDuration `json:"idle_timeout"`
+	SyncRateLimit    jsonDuration `json:"sync_rate_limit"`
+	SyncTimeout      jsonDuration `json:"sync_timeout"`
+	StaticMaxAge     jsonDuration `json:"static_max_age"`
+	DBDriver         string       `json:"db_driver"`
+	DBPath           string       `json:"db_path"`
+	DatabaseURL      string       `json:"database_url"`
+	DBMaxOpenConns   int          `json:"db_max_open_conns"`
+	DBMaxIdleConns   int          `json:"db_max_idle_conns"`
+	DBConnLifetime   jsonDuration `json:"db_conn_max_lifetime"`
+	SQLiteBusy       jsonDuration `json:"sqlite_busy_timeout"`
+	LogLevel         string       `json:"log_level"`
+	LogFormat        string       `json:"log_format"`
+	Cache            string       `json:"cache"`
+	FetchContent     bool         `json:"fetch_content"`
+	DedupBy          string       `json:"dedup_by"`
+	HideSeen         string       `json:"hide_seen"`
+	RefreshCounts    bool         `json:"refresh_counts"`
+	RetentionDays    int          `json:"retention_days"`
+	PruneMode        string       `json:"prune_mode"`
+	SMTPHost         string       `json:"smtp_host"`
+	SMTPPort         string       `json:"smtp_port"`
+	SMTPUser         string       `json:"smtp_user"`
+	SMTPPass         string       `json:"smtp_pass"`
+	SMTPFrom         string       `json:"smtp_from"`
+	SMTPTo           []string     `json:"smtp_to"`
+	DigestSchedule   string       `json:"digest_schedule"`
+	WebhookURL       string       `json:"webhook_url"`
+	UserAgent        string       `json:"user_agent"`
+	MaxFeedBytes     int64        `json:"max_feed_bytes"`
+	TLSCert          string       `json:"tls_cert"`
+	TLSKey           string       `json:"tls_key"`
+	SiteTitle        string       `json:"site_title"`
+	SiteSubtitle     string       `json:"site_subtitle"`
+	StoreRawItems    bool         `json:"store_raw_items"`
+	RawItemDays      int          `json:"raw_item_retention_days"`
+	VacuumAfterPrune bool         `json:"vacuum_after_prune"`
+	CORSOrigins      []string     `json:"cors_origins"`
+	AuthUser         string       `json:"auth_user"`
+	AuthPass         string       `json:"auth_pass"`
+	APIToken         string       `json:"api_token"`
+	APITokenPages    bool         `json:"api_token_pages"`
+	MetricsEnabled   bool         `json:"metrics_enabled"`
+}
+
+// jsonDuration is a time.Duration that reads from JSON strings like "2h" or "90m"
+type jsonDuration struct {
+	time.Duration
+}
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2h\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// defaultConfig returns the built-in configuration
+func defaultConfig() Config {
+	return Config{
+		Port:            "8080",
+		FeedURLs:        []string{defaultFeedURL},
+		RefreshInterval: jsonDuration{2 * time.Hour},
+		ReadTimeout:     jsonDuration{15 * time.Second},
+		WriteTimeout:    jsonDuration{15 * time.Second},
+		IdleTimeout:     jsonDuration{60 * time.Second},
+		SyncRateLimit:   jsonDuration{time.Minute},
+		SyncTimeout:     jsonDuration{2 * time.Minute},
+		StaticMaxAge:    jsonDuration{24 * time.Hour},
+		DBDriver:        dbDriverSQLite,
+		DBPath:          "./db/hn_reader.db",
+		DBMaxOpenConns:  25,
+		DBMaxIdleConns:  5,
+		DBConnLifetime:  jsonDuration{5 * time.Minute},
+		SQLiteBusy:      jsonDuration{5 * time.Second},
+		LogLevel:        "info",
+		LogFormat:       logFormatText,
+		Cache:           "on",
+		DedupBy:         dedupByLinkPair,
+		HideSeen:        hideSeenOff,
+		RetentionDays:   90,
+		PruneMode:       pruneModeArchive,
+		SMTPPort:        "587",
+		DigestSchedule:  digestScheduleSync,
+		UserAgent:       defaultUserAgent(),
+		SiteTitle:       defaultSiteTitle,
+		MaxFeedBytes:    5 << 20,
+		StoreRawItems:   true,
+		RawItemDays:     30,
+		MetricsEnabled:  true,
+	}
+}
+
+// loadConfig builds the configuration from defaults, the optional JSON file at
+// path, and environment variable overrides, then validates the result
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return cfg, fmt.Errorf("failed to read config file: %w", err)
+		}
+		defer f.Close()
+
+		decoder := json.NewDecoder(f)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&cfg); err != nil {
+			return cfg, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	}
+
+	if v, ok := os.LookupEnv("HOST"); ok {
+		cfg.Host = v
+	}
+	if v := os.Getenv("PORT"); v != "" {
+		cfg.Port = v
+	}
+	if v := os.Getenv("ASSETS_DIR"); v != "" {
+		cfg.AssetsDir = v
+	}
+	if v := os.Getenv("DEV"); v != "" {
+		dev, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid DEV: %w", err)
+		}
+		cfg.Dev = dev
+	}
+	// The embedded templates never change, so dev mode reads them from the
+	// working directory unless told otherwise
+	if cfg.Dev && cfg.AssetsDir == "" {
+		cfg.AssetsDir = "."
+	}
+	if v := os.Getenv("FEED_URL"); v != "" {
+		urls, err := parseFeedURLs(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid FEED_URL: %w", err)
+		}
+		cfg.FeedURLs = urls
+	}
+	if v := os.Getenv("REFRESH_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid REFRESH_INTERVAL: %w", err)
+		}
+		cfg.RefreshInterval = jsonDuration{interval}
+	}
+	for name, target := range map[string]*jsonDuration{
+		"READ_TIMEOUT":         &cfg.ReadTimeout,
+		"WRITE_TIMEOUT":        &cfg.WriteTimeout,
+		"IDLE_TIMEOUT":         &cfg.IdleTimeout,
+		"SYNC_RATE_LIMIT":      &cfg.SyncRateLimit,
+		"SYNC_TIMEOUT":         &cfg.SyncTimeout,
+		"STATIC_MAX_AGE":       &cfg.StaticMaxAge,
+		"DB_CONN_MAX_LIFETIME": &cfg.DBConnLifetime,
+		"SQLITE_BUSY_TIMEOUT":  &cfg.SQLiteBusy,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = jsonDuration{d}
+		}
+	}
+	if v := os.Getenv("DB_DRIVER"); v != "" {
+		cfg.DBDriver = v
+	}
+	if v := os.Getenv("DB_PATH"); v != "" {
+		cfg.DBPath = v
+	}
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		cfg.DatabaseURL = v
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		cfg.LogLevel = v
+	}
+	if v := os.Getenv("LOG_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
+	if v := os.Getenv("CACHE"); v != "" {
+		cfg.Cache = v
+	}
+	if v := os.Getenv("DEDUP_BY"); v != "" {
+		cfg.DedupBy = v
+	}
+	if v := os.Getenv("HIDE_SEEN"); v != "" {
+		cfg.HideSeen = v
+	}
+	if v := os.Getenv("REFRESH_COUNTS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid REFRESH_COUNTS: %w", err)
+		}
+		cfg.RefreshCounts = enabled
+	}
+	if v := os.Getenv("FETCH_CONTENT"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid FETCH_CONTENT: %w", err)
+		}
+		cfg.FetchContent = enabled
+	}
+	for name, target := range map[string]*int{
+		"DB_MAX_OPEN_CONNS": &cfg.DBMaxOpenConns,
+		"DB_MAX_IDLE_CONNS": &cfg.DBMaxIdleConns,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*target = n
+		}
+	}
+	if v := os.Getenv("RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RETENTION_DAYS: %w", err)
+		}
+		cfg.RetentionDays = days
+	}
+	if v := os.Getenv("PRUNE_MODE"); v != "" {
+		cfg.PruneMode = v
+	}
+	if v := os.Getenv("STORE_RAW_ITEMS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid STORE_RAW_ITEMS: %w", err)
+		}
+		cfg.StoreRawItems = enabled
+	}
+	if v := os.Getenv("RAW_ITEM_RETENTION_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid RAW_ITEM_RETENTION_DAYS: %w", err)
+		}
+		cfg.RawItemDays = days
+	}
+	if v := os.Getenv("VACUUM_AFTER_PRUNE"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid VACUUM_AFTER_PRUNE: %w", err)
+		}
+		cfg.VacuumAfterPrune = enabled
+	}
+	if v := os.Getenv("SMTP_HOST"); v != "" {
+		cfg.SMTPHost = v
+	}
+	if v := os.Getenv("SMTP_PORT"); v != "" {
+		cfg.SMTPPort = v
+	}
+	if v := os.Getenv("SMTP_USER"); v != "" {
+		cfg.SMTPUser = v
+	}
+	if v := os.Getenv("SMTP_PASS"); v != "" {
+		cfg.SMTPPass = v
+	}
+	if v := os.Getenv("SMTP_FROM"); v != "" {
+		cfg.SMTPFrom = v
+	}
+	if v := os.Getenv("SMTP_TO"); v != "" {
+		cfg.SMTPTo = parseAddressList(v)
+	}
+	if v := os.Getenv("DIGEST_SCHEDULE"); v != "" {
+		cfg.DigestSchedule = v
+	}
+	if v := os.Getenv("WEBHOOK_URL"); v != "" {
+		cfg.WebhookURL = v
+	}
+	if v := os.Getenv("USER_AGENT"); v != "" {
+		cfg.UserAgent = v
+	}
+	if v := os.Getenv("MAX_FEED_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid MAX_FEED_BYTES: %w", err)
+		}
+		cfg.MaxFeedBytes = n
+	}
+	if v := os.Getenv("TLS_CERT"); v != "" {
+		cfg.TLSCert = v
+	}
+	if v := os.Getenv("TLS_KEY"); v != "" {
+		cfg.TLSKey = v
+	}
+	if v := os.Getenv("CORS_ORIGINS"); v != "" {
+		cfg.CORSOrigins = parseAddressList(v)
+	}
+	if v := os.Getenv("AUTH_USER"); v != "" {
+		cfg.AuthUser = v
+	}
+	if v := os.Getenv("AUTH_PASS"); v != "" {
+		cfg.AuthPass = v
+	}
+	if v := os.Getenv("API_TOKEN"); v != "" {
+		cfg.APIToken = v
+	}
+	if v := os.Getenv("API_TOKEN_PAGES"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid API_TOKEN_PAGES: %w", err)
+		}
+		cfg.APITokenPages = enabled
+	}
+	if v := os.Getenv("METRICS_ENABLED"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid METRICS_ENABLED: %w", err)
+		}
+		cfg.MetricsEnabled = enabled
+	}
+	if v := os.Getenv("SITE_TITLE"); v != "" {
+		cfg.SiteTitle = v
+	}
+	if v := os.Getenv("SITE_SUBTITLE"); v != "" {
+		cfg.SiteSubtitle = v
+	}
+
+	if err := cfg.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// displayHost returns a host to show in the listening URL, using localhost when
+// binding to every interface
+func displayHost(host string) string {
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return "localhost"
+	}
+	return host
+}
+
+// validate checks that every setting is usable
+func (c Config) validate() error {
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("port must be a number between 1 and 65535, got %q", c.Port)
+	}
+	if strings.ContainsAny(c.Host, " /") || (strings.Contains(c.Host, ":") && net.ParseIP(c.Host) == nil) {
+		return fmt.Errorf("host must be an IP address or hostname without a port, got %q", c.Host)
+	}
+	if len(c.FeedURLs) == 0 {
+		return fmt.Errorf("at least one feed URL is required")
+	}
+	for _, u := range c.FeedURLs {
+		if err := validateFeedURL(u); err != nil {
+			return fmt.Errorf("invalid feed URL %q: %w", u, err)
+		}
+	}
+	if c.RefreshInterval.Duration < time.Minute {
+		return fmt.Errorf("refresh interval must be at least 1m, got %s", c.RefreshInterval)
+	}
+	if c.ReadTimeout.Duration <= 0 || c.WriteTimeout.Duration <= 0 || c.IdleTimeout.Duration <= 0 {
+		return fmt.Errorf("read, write and idle timeouts must be positive, got %s, %s and %s",
+			c.ReadTimeout, c.WriteTimeout, c.IdleTimeout)
+	}
+	if c.SyncRateLimit.Duration < 0 {
+		return fmt.Errorf("sync rate limit must not be negative, got %s", c.SyncRateLimit)
+	}
+	if c.SyncTimeout.Duration <= 0 {
+		return fmt.Errorf("sync timeout must be positive, got %s", c.SyncTimeout)
+	}
+	if c.StaticMaxAge.Duration < 0 {
+		return fmt.Errorf("static max age must not be negative, got %s", c.StaticMaxAge)
+	}
+	if c.DBMaxOpenConns < 1 {
+		return fmt.Errorf("db max open conns must be at least 1, got %d", c.DBMaxOpenConns)
+	}
+	if c.DBMaxIdleConns < 0 || c.DBMaxIdleConns > c.DBMaxOpenConns {
+		return fmt.Errorf("db max idle conns must be between 0 and db max open conns (%d), got %d",
+			c.DBMaxOpenConns, c.DBMaxIdleConns)
+	}
+	if c.DBConnLifetime.Duration < 0 {
+		return fmt.Errorf("db conn max lifetime must not be negative, got %s", c.DBConnLifetime)
+	}
+	if c.SQLiteBusy.Duration < 0 {
+		return fmt.Errorf("sqlite busy timeout must not be negative, got %s", c.SQLiteBusy)
+	}
+	switch c.DBDriver {
+	case dbDriverSQLite:
+		if c.DBPath == "" {
+			return fmt.Errorf("db path must not be empty")
+		}
+	case dbDriverPostgres:
+		if c.DatabaseURL == "" {
+			return fmt.Errorf("database URL is required when the db driver is %q", dbDriverPostgres)
+		}
+	default:
+		return fmt.Errorf("db driver must be %q or %q, got %q", dbDriverSQLite, dbDriverPostgres, c.DBDriver)
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		return err
+	}
+	if c.LogFormat != logFormatText && c.LogFormat != logFormatJSON {
+		return fmt.Errorf("log format must be %q or %q, got %q", logFormatText, logFormatJSON, c.LogFormat)
+	}
+	if c.DedupBy != dedupByLinkPair && c.DedupBy != dedupByLink {
+		return fmt.Errorf("dedup mode must be %q or %q, got %q", dedupByLinkPair, dedupByLink, c.DedupBy)
+	}
+	if c.HideSeen != hideSeenOff && c.HideSeen != hideSeenRead && c.HideSeen != hideSeenSkip {
+		return fmt.Errorf("hide seen must be %q, %q or %q, got %q", hideSeenOff, hideSeenRead, hideSeenSkip, c.HideSeen)
+	}
+	if c.Cache != "on" && c.Cache != "off" {
+		return fmt.Errorf("cache must be \"on\" or \"off\", got %q", c.Cache)
+	}
+	if c.RetentionDays < 1 {
+		return fmt.Errorf("retention days must be at least 1, got %d", c.RetentionDays)
+	}
+	if c.PruneMode != pruneModeArchive && c.PruneMode != pruneModeDelete {
+		return fmt.Errorf("prune mode must be %q or %q, got %q", pruneModeArchive, pruneModeDelete, c.PruneMode)
+	}
+	if c.RawItemDays < 1 {
+		return fmt.Errorf("raw item retention days must be at least 1, got %d", c.RawItemDays)
+	}
+	if c.VacuumAfterPrune && c.DBDriver != dbDriverSQLite {
+		return fmt.Errorf("vacuum after prune is only supported with the %q db driver", dbDriverSQLite)
+	}
+	if c.SMTPHost != "" {
+		smtpPort, err := strconv.Atoi(c.SMTPPort)
+		if err != nil || smtpPort < 1 || smtpPort > 65535 {
+			return fmt.Errorf("smtp port must be a number between 1 and 65535, got %q", c.SMTPPort)
+		}
+		if c.SMTPFrom == "" || len(c.SMTPTo) == 0 {
+			return fmt.Errorf("smtp from and to addresses are required when an smtp host is set")
+		}
+		if c.DigestSchedule != digestScheduleSync && c.DigestSchedule != digestScheduleDaily {
+			return fmt.Errorf("digest schedule must be %q or %q, got %q", digestScheduleSync, digestScheduleDaily, c.DigestSchedule)
+		}
+	}
+	if c.MaxFeedBytes < 1 {
+		return fmt.Errorf("max feed bytes must be positive, got %d", c.MaxFeedBytes)
+	}
+	if strings.TrimSpace(c.UserAgent) == "" || strings.ContainsAny(c.UserAgent, "\r\n") {
+		return fmt.Errorf("user agent must be a non-empty single line, got %q", c.UserAgent)
+	}
+	// The title also ends up in digest email subjects, so it can't span lines
+	if strings.TrimSpace(c.SiteTitle) == "" || strings.ContainsAny(c.SiteTitle, "\r\n") {
+		return fmt.Errorf("site title must be a non-empty single line, got %q", c.SiteTitle)
+	}
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return fmt.Errorf("CORS origin must be \"*\" or a scheme and host like https://example.com, got %q", origin)
+		}
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("tls cert and key must be set together")
+	}
+	if (c.AuthUser == "") != (c.AuthPass == "") {
+		return fmt.Errorf("auth user and pass must be set together")
+	}
+	if c.APITokenPages && c.APIToken == "" {
+		return fmt.Errorf("api token pages requires an api token")
+	}
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("webhook URL must be an absolute http or https URL, got %q", c.WebhookURL)
+		}
+	}
+	return nil
+}
+
+// parseLogLevel converts a level name (debug, info, warn, error) to a slog.Level
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return level, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", name)
+	}
+	return level, nil
+}
+
+// DB_PATH value for an ephemeral database that's discarded on exit
+const inMemoryDBPath = ":memory:"
+
+// Whether the database is SQLite's :memory:, whose only connection must never be closed
+var dbInMemory bool
+
+// Idle connections kept in the pool, restored after the pool is reset
+var dbMaxIdleConns = 5
+
+// initDB opens the database selected by the config and brings its schema up to date
+func initDB(cfg Config) error {
+	pool := dbPoolSettings{
+		maxOpen:     cfg.DBMaxOpenConns,
+		maxIdle:     cfg.DBMaxIdleConns,
+		maxLifetime: cfg.DBConnLifetime.Duration,
+	}
+	if cfg.DBDriver == dbDriverPostgres {
+		if err := openPostgres(cfg.DatabaseURL, pool); err != nil {
+			return err
+		}
+		store = newPostgresStore(db)
+	} else {
+		if err := openSQLite(cfg.DBPath, cfg.SQLiteBusy.Duration, pool); err != nil {
+			return err
+		}
+		store = newSQLiteStore(db)
+	}
+	if err := store.migrate(); err != nil {
+		return err
+	}
+
+	slog.Info("Database initialized successfully")
+	return nil
+}
+
+// dbPoolSettings are the connection pool limits from DB_MAX_OPEN_CONNS,
+// DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME
+type dbPoolSettings struct {
+	maxOpen     int
+	maxIdle     int
+	maxLifetime time.Duration
+}
+
+// apply sets the pool limits on db and logs them
+func (p dbPoolSettings) apply() {
+	db.SetMaxOpenConns(p.maxOpen)
+	db.SetMaxIdleConns(p.maxIdle)
+	db.SetConnMaxLifetime(p.maxLifetime)
+	dbMaxIdleConns = p.maxIdle
+	slog.Info("Database connection pool", "max_open", p.maxOpen, "max_idle", p.maxIdle, "max_lifetime", p.maxLifetime)
+}
+
+// sqliteDSN returns the connection string for a file-backed SQLite database.
+// SQLite allows one writer at a time, and with the default rollback journal a
+// writer also blocks readers, so a pool of connections can hit "database is
+// locked". WAL lets reads carry on during a write, and the busy timeout makes a
+// second writer wait up to busyTimeout for the lock instead of failing. The
+// alternative, DB_MAX_OPEN_CONNS=1, avoids contention entirely but makes every
+// request queue behind the slowest query.
+func sqliteDSN(dbPath string, busyTimeout time.Duration) string {
+	return fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=%d", dbPath, busyTimeout.Milliseconds())
+}
+
+// openSQLite opens the SQLite database at dbPath
+func openSQLite(dbPath string, busyTimeout time.Duration, pool dbPoolSettings) error {
+	if dbPath == inMemoryDBPath {
+		slog.Info("Using in-memory database, data will not be persisted")
+	} else {
+		// Create db directory if it doesn't exist
+		if err := os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+			return fmt.Errorf("failed to create db directory: %w", err)
+		}
+		if absPath, err := filepath.Abs(dbPath); err == nil {
+			dbPath = absPath
+		}
+		slog.Info("Using database", "path", dbPath)
+	}
+
+	dsn := dbPath
+	if dbPath != inMemoryDBPath {
+		dsn = sqliteDSN(dbPath, busyTimeout)
+		slog.Info("SQLite write lock wait", "busy_timeout", busyTimeout)
+	}
+
+	var err error
+	if tracingEnabled {
+		db, err = otelsql.Open("sqlite3", dsn, otelsql.WithAttributes(attribute.String("db.system.name", "sqlite")))
+	} else {
+		db, err = sql.Open("sqlite3", dsn)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if dbPath == inMemoryDBPath {
+		// Every connection to :memory: gets its own empty database, so keep
+		// exactly one connection open for the life of the process, whatever
+		// the pool settings say
+		dbInMemory = true
+		pool = dbPoolSettings{maxOpen: 1, maxIdle: 1}
+	}
+	pool.apply()
+	return nil
+}
+
+// openPostgres connects to the PostgreSQL database at databaseURL
+func openPostgres(databaseURL string, pool dbPoolSettings) error {
+	pgConfig, err := pq.NewConfig(databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to parse database URL: %w", err)
+	}
+
+	// Timestamps are stored without a time zone and compared with UTC values,
+	// so pin every session to UTC to match SQLite's CURRENT_TIMESTAMP
+	if pgConfig.Runtime == nil {
+		pgConfig.Runtime = map[string]string{}
+	}
+	pgConfig.Runtime["timezone"] = "UTC"
+
+	connector, err := pq.NewConnectorConfig(pgConfig)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	if tracingEnabled {
+		db = otelsql.OpenDB(connector, otelsql.WithAttributes(attribute.String("db.system.name", "postgresql")))
+	} else {
+		db = sql.OpenDB(connector)
+	}
+	pool.apply()
+
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	slog.Info("Using PostgreSQL database", "host", pgConfig.Host, "database", pgConfig.Database)
+	return nil
+}
+
+// Store is the data layer. Handlers and background jobs go through the store
+// that initDB opens rather than querying the database themselves, so the SQL
+// dialect only matters to the sqliteStore and postgresStore implementations.
+type Store interface {
+	// migrate brings the schema up to date
+	migrate() error
+	CheckHealth(ctx context.Context) error
+
+	ListArticles(ctx context.Context, filter ArticleFilter, limit, offset int) ([]Article, error)
+	CountArticles(ctx context.Context, filter ArticleFilter) (int, error)
+	ForEachArticle(ctx context.Context, fn func(Article) error) error
+	GetArticle(ctx context.Context, id int) (Article, error)
+	UnreadCount(ctx context.Context) (int, error)
+	UnreadArticleIDs(ctx context.Context) ([]int, error)
+	HostCounts(ctx context.Context, filter ArticleFilter) ([]HostCount, error)
+	LaterArticles(ctx context.Context) ([]Article, error)
+	StarredArticles(ctx context.Context) ([]Article, error)
+	RecentlyRead(ctx context.Context, since time.Time) ([]Article, error)
+	ArticlesByTag(ctx context.Context, tag string) ([]Article, error)
+
+	SaveArticles(ctx context.Context, articles []Article) ([]Article, error)
+	ImportArticles(ctx context.Context, articles []Article) (inserted, skipped int, err error)
+	MarkRead(ctx context.Context, id int, read bool) (int64, error)
+	MarkManyRead(ctx context.Context, ids []int, read bool) (int64, error)
+	MarkAllRead(ctx context.Context, before time.Time) (int64, error)
+	MarkUnreadByLinks(ctx context.Context, article Article) error
+	ToggleRead(ctx context.Context, id int) (int64, error)
+	MarkLater(ctx context.Context, id int) (int64, error)
+	MarkStarred(ctx context.Context, id int, starred bool) (int64, error)
+	Snooze(ctx context.Context, id int, until time.Time) (int64, error)
+	WakeSnoozed(ctx context.Context)
+	DeleteArticle(ctx context.Context, id int) (int64, error)
+	PruneArticles(ctx context.Context, cutoff time.Time, mode string) (int64, error)
+	AddTag(ctx context.Context, articleID int, tag string) error
+	RemoveTag(ctx context.Context, articleID int, tag string) (int64, error)
+
+	ArticlesMissingContent(ctx context.Context, limit int) ([]Article, error)
+	GetContent(ctx context.Context, id int) (string, error)
+	SaveContent(ctx context.Context, id int, content string) error
+
+	SaveRawItems(ctx context.Context, feedURL string, items []Item, articles []Article) error
+	PruneRawItems(ctx context.Context, cutoff time.Time) (int64, error)
+	ReparseArticles(ctx context.Context) (ReparseResult, error)
+
+	GetMeta(ctx context.Context, key string) (string, error)
+	SetMeta(ctx context.Context, key, value string) error
+	GetFeedMeta(ctx context.Context, feedURL string) (etag, lastModified string, err error)
+	SaveFeedMeta(ctx context.Context, feedURL, etag, lastModified, title string) error
+	GetFeedTitle(ctx context.Context, feedURL string) (string, error)
+	SaveSyncRun(ctx context.Context, run SyncRun) error
+	SyncRuns(ctx context.Context, limit int) ([]SyncRun, error)
+
+	// SearchEnabled reports whether Search is available
+	SearchEnabled() bool
+	// Search returns articles whose titles match an FTS query, best matches first
+	Search(ctx context.Context, query string) ([]Article, error)
+	// Vacuum gives the space freed by pruning back to the filesystem
+	Vacuum(ctx context.Context) (VacuumResult, error)
+}
+
+// The store for the open database, set by initDB
+var store Store
+
+// Returned by stores whose database doesn't support an operation
+var (
+	errSearchUnsupported = errors.New("search is not supported by this database")
+	errVacuumUnsupported = errors.New("vacuum is not supported by this database")
+)
+
+// sqlStore holds the queries both databases share. They're written with ?
+// placeholders and passed through rebind, which converts them for the driver.
+type sqlStore struct {
+	db     *sql.DB
+	rebind func(query string) string
+}
+
+// sqliteStore is the default store, a SQLite database file or :memory:
+type sqliteStore struct {
+	*sqlStore
+	// Whether the SQLite build includes FTS5, set by migrate
+	fts bool
+}
+
+func newSQLiteStore(db *sql.DB) *sqliteStore {
+	return &sqliteStore{sqlStore: &sqlStore{db: db, rebind: func(query string) string { return query }}}
+}
+
+func (s *sqliteStore) migrate() error {
+	if err := s.runMigrations(sqliteMigrations); err != nil {
+		return err
+	}
+	// The search index is optional, so it lives outside the migrations
+	return s.initSearchIndex()
+}
+
+func (s *sqliteStore) SearchEnabled() bool {
+	return s.fts
+}
+
+// postgresStore keeps articles in PostgreSQL, for several instances sharing one database
+type postgresStore struct {
+	*sqlStore
+}
+
+func newPostgresStore(db *sql.DB) *postgresStore {
+	return &postgresStore{sqlStore: &sqlStore{db: db, rebind: numberPlaceholders}}
+}
+
+func (s *postgresStore) migrate() error {
+	if err := s.runMigrations(postgresMigrations); err != nil {
+		return err
+	}
+	slog.Info("Search is only available with SQLite, it is disabled")
+	return nil
+}
+
+func (s *postgresStore) SearchEnabled() bool {
+	return false
+}
+
+func (s *postgresStore) Search(ctx context.Context, query string) ([]Article, error) {
+	return nil, errSearchUnsupported
+}
+
+func (s *postgresStore) Vacuum(ctx context.Context) (VacuumResult, error) {
+	return VacuumResult{}, errVacuumUnsupported
+}
+
+// numberPlaceholders rewrites the ? placeholders in a query into the $1, $2, ...
+// style Postgres expects. Queries must not contain a literal question mark.
+func numberPlaceholders(query string) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// initSearchIndex creates the FTS5 index over article titles, kept in sync by triggers.
+// Search is disabled rather than failing startup if SQLite was built without FTS5.
+func (s *sqliteStore) initSearchIndex() error {
+	var fts5 bool
+	if err := s.db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5); err != nil {
+		return fmt.Errorf("failed to check for FTS5: %w", err)
+	}
+	if !fts5 {
+		// A database indexed by an FTS5 build still has the triggers, which would
+		// make every write to articles fail with "no such module"
+		_, err := s.db.Exec(`
+		DROP TRIGGER IF EXISTS articles_fts_insert;
+		DROP TRIGGER IF EXISTS articles_fts_delete;
+		DROP TRIGGER IF EXISTS articles_fts_update;`)
+		if err != nil {
+			return fmt.Errorf("failed to drop search triggers: %w", err)
+		}
+		slog.Warn("SQLite built without FTS5, search is disabled (build with -tags sqlite_fts5)")
+		return nil
+	}
+
+	// The index is stale if the table or its triggers are missing, either because
+	// it's new or because a build without FTS5 dropped the triggers
+	var triggers int
+	err := s.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'articles_fts_%'`).Scan(&triggers)
+	if err != nil {
+		return fmt.Errorf("failed to check search index: %w", err)
+	}
+
+	_, err = s.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS articles_fts USING fts5(
+		title,
+		content='articles',
+		content_rowid='id'
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	triggersSQL := `
+	CREATE TRIGGER IF NOT EXISTS articles_fts_insert AFTER INSERT ON articles BEGIN
+		INSERT INTO articles_fts(rowid, title) VALUES (new.id, new.title);
+	END;
+	CREATE TRIGGER IF NOT EXISTS articles_fts_delete AFTER DELETE ON articles BEGIN
+		INSERT INTO articles_fts(articles_fts, rowid, title) VALUES ('delete', old.id, old.title);
+	END;
+	CREATE TRIGGER IF NOT EXISTS articles_fts_update AFTER UPDATE OF title ON articles BEGIN
+		INSERT INTO articles_fts(articles_fts, rowid, title) VALUES ('delete', old.id, old.title);
+		INSERT INTO articles_fts(rowid, title) VALUES (new.id, new.title);
+	END;`
+	if _, err := s.db.Exec(triggersSQL); err != nil {
+		return fmt.Errorf("failed to create search triggers: %w", err)
+	}
+
+	// Index articles that were stored before the search index existed or while
+	// it wasn't kept up to date
+	if triggers < 3 {
+		if _, err := s.db.Exec(`INSERT INTO articles_fts(articles_fts) VALUES ('rebuild')`); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+
+	s.fts = true
+	return nil
+}
+
+// migration is a single schema change, applied once in its own transaction
+type migration struct {
+	version     int
+	description string
+	up          func(s *sqlStore, tx *sql.Tx) error
+}
+
+// sqliteMigrations lists every schema change in order. Append new migrations to the end
+// and never edit or reorder ones that have shipped.
+//
+// Databases created before migrations existed already have some of these changes,
+// so migrations that add columns use addColumnIfMissing and tables use IF NOT EXISTS.
+var sqliteMigrations = []migration{
+	{1, "create articles table", execMigration(`
+		CREATE TABLE IF NOT EXISTS articles (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			date TEXT NOT NULL,
+			article_link TEXT NOT NULL,
+			comment_link TEXT NOT NULL,
+			title TEXT NOT NULL,
+			read INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(article_link, comment_link)
+		);`)},
+	{2, "add articles.source", addColumnMigration("articles", "source", "TEXT NOT NULL DEFAULT ''")},
+	{3, "create feed_meta table", execMigration(`
+		CREATE TABLE IF NOT EXISTS feed_meta (
+			feed_url TEXT PRIMARY KEY,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT ''
+		);`)},
+	{4, "create meta table", execMigration(`
+		CREATE TABLE IF NOT EXISTS meta (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);`)},
+	{5, "add articles.points", addColumnMigration("articles", "points", "INTEGER NOT NULL DEFAULT 0")},
+	{6, "add articles.comment_count", addColumnMigration("articles", "comment_count", "INTEGER NOT NULL DEFAULT 0")},
+	{7, "add feed_meta.title", addColumnMigration("feed_meta", "title", "TEXT NOT NULL DEFAULT ''")},
+	{8, "add articles.read_at", addColumnMigration("articles", "read_at", "DATETIME")},
+	{9, "add articles.starred", addColumnMigration("articles", "starred", "INTEGER NOT NULL DEFAULT 0")},
+	// Tag names are stored lowercased so they're case-insensitive, and an
+	// article's tags are removed along with it
+	{10, "create tag tables", execMigration(`
+		CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE
+		);
+		CREATE TABLE IF NOT EXISTS article_tags (
+			article_id INTEGER NOT NULL,
+			tag_id INTEGER NOT NULL,
+			PRIMARY KEY (article_id, tag_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_tags_tag ON article_tags(tag_id);
+		CREATE TRIGGER IF NOT EXISTS articles_tags_delete AFTER DELETE ON articles BEGIN
+			DELETE FROM article_tags WHERE article_id = old.id;
+		END;`)},
+	// Old read articles are moved here when PRUNE_MODE=archive
+	{11, "create archived_articles table", execMigration(`
+		CREATE TABLE IF NOT EXISTS archived_articles (
+			id INTEGER PRIMARY KEY,
+			date TEXT NOT NULL,
+			article_link TEXT NOT NULL,
+			comment_link TEXT NOT NULL,
+			title TEXT NOT NULL,
+			source TEXT NOT NULL DEFAULT '',
+			points INTEGER NOT NULL DEFAULT 0,
+			comment_count INTEGER NOT NULL DEFAULT 0,
+			read_at DATETIME,
+			created_at DATETIME,
+			archived_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`)},
+	// Lets the home page's read filter and newest-first ordering use a single index
+	{12, "index articles by read and created_at", execMigration(`
+		CREATE INDEX IF NOT EXISTS idx_articles_read_created ON articles(read, created_at, id);`)},
+	// NULL content means not fetched yet. Existing articles are marked as done so
+	// enabling FETCH_CONTENT only fetches articles added from then on.
+	{13, "add articles.content", func(s *sqlStore, tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "articles", "content", "TEXT"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`UPDATE articles SET content = '' WHERE content IS NULL`)
+		return err
+	}},
+	// status is new, later or read, and is kept in step with read, which stays
+	// for everything that only cares whether an article was read
+	{14, "add articles.status", func(s *sqlStore, tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "articles", "status", "TEXT NOT NULL DEFAULT 'new'"); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			UPDATE articles SET status = 'read' WHERE read = 1;
+			CREATE INDEX IF NOT EXISTS idx_articles_status_created ON articles(status, created_at, id);`)
+		return err
+	}},
+	{15, "add articles.host", func(s *sqlStore, tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "articles", "host", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return s.backfillArticleHosts(tx)
+	}},
+	{16, "create sync_runs table", execMigration(`
+		CREATE TABLE IF NOT EXISTS sync_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			started_at DATETIME NOT NULL,
+			duration_ms INTEGER NOT NULL,
+			new_articles INTEGER NOT NULL,
+			success INTEGER NOT NULL,
+			outcome TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT ''
+		);`)},
+	// NULL until readable content has been saved
+	{17, "add articles.reading_minutes", func(s *sqlStore, tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "articles", "reading_minutes", "INTEGER"); err != nil {
+			return err
+		}
+		return s.backfillReadingMinutes(tx)
+	}},
+	// The whole item is kept rather than just its description, since standard
+	// feeds carry the story in the title, link and comments instead
+	{18, "add articles.raw_item", addColumnMigration("articles", "raw_item", "TEXT NOT NULL DEFAULT ''")},
+	// Raw items move to their own table so an item shared by several articles is
+	// stored once and items that parsed to nothing are kept too. articles.raw_item
+	// is emptied and no longer written.
+	{19, "create raw_items table", func(s *sqlStore, tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS raw_items (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				feed_url TEXT NOT NULL,
+				item_hash TEXT NOT NULL,
+				pub_date TEXT NOT NULL DEFAULT '',
+				item TEXT NOT NULL,
+				first_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+				last_seen DATETIME DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(feed_url, item_hash)
+			);
+			CREATE INDEX IF NOT EXISTS idx_raw_items_last_seen ON raw_items(last_seen);`)
+		if err != nil {
+			return err
+		}
+		if err := addColumnIfMissing(tx, "articles", "raw_item_id", "INTEGER"); err != nil {
+			return err
+		}
+		return s.moveRawItems(tx)
+	}},
+	// NULL unless the article is hidden until a future time
+	{20, "add articles.snoozed_until", addColumnMigration("articles", "snoozed_until", "DATETIME")},
+	// Normalized title for HIDE_SEEN, see titleKey
+	{21, "add articles.title_key", func(s *sqlStore, tx *sql.Tx) error {
+		if err := addColumnIfMissing(tx, "articles", "title_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_articles_title_key ON articles(title_key)`); err != nil {
+			return err
+		}
+		return s.backfillTitleKeys(tx)
+	}},
+}
+
+// postgresMigrations is the PostgreSQL equivalent of sqliteMigrations. Postgres support
+// was added at schema version 11, so the first migration creates that schema in one go.
+// New migrations must be added to both lists with the same version.
+var postgresMigrations = []migration{
+	// Tags are removed along with their article by the foreign key rather than a trigger
+	{11, "create schema", execMigration(`
+		CREATE TABLE IF NOT EXISTS articles (
+			id BIGSERIAL PRIMARY KEY,
+			date TEXT NOT NULL,
+			article_link TEXT NOT NULL,
+			comment_link TEXT NOT NULL,
+			title TEXT NOT NULL,
+			read INTEGER DEFAULT 0,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			source TEXT NOT NULL DEFAULT '',
+			points INTEGER NOT NULL DEFAULT 0,
+			comment_count INTEGER NOT NULL DEFAULT 0,
+			read_at TIMESTAMP,
+			starred INTEGER NOT NULL DEFAULT 0,
+			UNIQUE(article_link, comment_link)
+		);
+		CREATE TABLE IF NOT EXISTS feed_meta (
+			feed_url TEXT PRIMARY KEY,
+			etag TEXT NOT NULL DEFAULT '',
+			last_modified TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT ''
+		);
+		CREATE TABLE IF NOT EXISTS meta (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS tags (
+			id BIGSERIAL PRIMARY KEY,
+			name TEXT NOT NULL UNIQUE
+		);
+		CREATE TABLE IF NOT EXISTS article_tags (
+			article_id BIGINT NOT NULL REFERENCES articles(id) ON DELETE CASCADE,
+			tag_id BIGINT NOT NULL REFERENCES tags(id),
+			PRIMARY KEY (article_id, tag_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_article_tags_tag ON article_tags(tag_id);
+		CREATE TABLE IF NOT EXISTS archived_articles (
+			id BIGINT PRIMARY KEY,
+			date TEXT NOT NULL,
+			article_link TEXT NOT NULL,
+			comment_link TEXT NOT NULL,
+			title TEXT NOT NULL,
+			source TEXT NOT NULL DEFAULT '',
+			points INTEGER NOT NULL DEFAULT 0,
+			comment_count INTEGER NOT NULL DEFAULT 0,
+			read_at TIMESTAMP,
+			created_at TIMESTAMP,
+			archived_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`)},
+	{12, "index articles by read and created_at", execMigration(`
+		CREATE INDEX IF NOT EXISTS idx_articles_read_created ON articles(read, created_at, id);`)},
+	{13, "add articles.content", execMigration(`
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS content TEXT;
+		UPDATE articles SET content = '' WHERE content IS NULL;`)},
+	{14, "add articles.status", execMigration(`
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'new';
+		UPDATE articles SET status = 'read' WHERE read = 1;
+		CREATE INDEX IF NOT EXISTS idx_articles_status_created ON articles(status, created_at, id);`)},
+	{15, "add articles.host", func(s *sqlStore, tx *sql.Tx) error {
+		if _, err := tx.Exec(`ALTER TABLE articles ADD COLUMN IF NOT EXISTS host TEXT NOT NULL DEFAULT ''`); err != nil {
+			return err
+		}
+		return s.backfillArticleHosts(tx)
+	}},
+	{16, "create sync_runs table", execMigration(`
+		CREATE TABLE IF NOT EXISTS sync_runs (
+			id BIGSERIAL PRIMARY KEY,
+			started_at TIMESTAMP NOT NULL,
+			duration_ms BIGINT NOT NULL,
+			new_articles INTEGER NOT NULL,
+			success INTEGER NOT NULL,
+			outcome TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT ''
+		);`)},
+	{17, "add articles.reading_minutes", func(s *sqlStore, tx *sql.Tx) error {
+		if _, err := tx.Exec(`ALTER TABLE articles ADD COLUMN IF NOT EXISTS reading_minutes INTEGER`); err != nil {
+			return err
+		}
+		return s.backfillReadingMinutes(tx)
+	}},
+	{18, "add articles.raw_item", execMigration(`
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS raw_item TEXT NOT NULL DEFAULT '';`)},
+	{19, "create raw_items table", func(s *sqlStore, tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE IF NOT EXISTS raw_items (
+				id BIGSERIAL PRIMARY KEY,
+				feed_url TEXT NOT NULL,
+				item_hash TEXT NOT NULL,
+				pub_date TEXT NOT NULL DEFAULT '',
+				item TEXT NOT NULL,
+				first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(feed_url, item_hash)
+			);
+			CREATE INDEX IF NOT EXISTS idx_raw_items_last_seen ON raw_items(last_seen);
+			ALTER TABLE articles ADD COLUMN IF NOT EXISTS raw_item_id BIGINT;`)
+		if err != nil {
+			return err
+		}
+		return s.moveRawItems(tx)
+	}},
+	{20, "add articles.snoozed_until", execMigration(`
+		ALTER TABLE articles ADD COLUMN IF NOT EXISTS snoozed_until TIMESTAMP;`)},
+	{21, "add articles.title_key", func(s *sqlStore, tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE articles ADD COLUMN IF NOT EXISTS title_key TEXT NOT NULL DEFAULT '';
+			CREATE INDEX IF NOT EXISTS idx_articles_title_key ON articles(title_key);`)
+		if err != nil {
+			return err
+		}
+		return s.backfillTitleKeys(tx)
+	}},
+}
+
+// moveRawItems copies the raw items saved on articles into raw_items and links
+// the articles to them
+func (s *sqlStore) moveRawItems(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT DISTINCT source, raw_item FROM articles WHERE raw_item != ''`)
+	if err != nil {
+		return err
+	}
+	type sourceItem struct{ source, rawItem string }
+	var items []sourceItem
+	for rows.Next() {
+		var it sourceItem
+		if err := rows.Scan(&it.source, &it.rawItem); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, it := range items {
+		var item Item
+		if err := json.Unmarshal([]byte(it.rawItem), &item); err != nil {
+			continue
+		}
+		var id int
+		if err := tx.QueryRow(s.rebind(upsertRawItemQuery), it.source, rawItemHash(it.rawItem), item.PubDate, it.rawItem).Scan(&id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(s.rebind(`UPDATE articles SET raw_item_id = ? WHERE source = ? AND raw_item = ?`), id, it.source, it.rawItem); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`UPDATE articles SET raw_item = '' WHERE raw_item != ''`)
+	return err
+}
+
+// backfillReadingMinutes estimates reading times for content saved before they were recorded
+func (s *sqlStore) backfillReadingMinutes(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, content FROM articles WHERE content != '' AND reading_minutes IS NULL`)
+	if err != nil {
+		return err
+	}
+	minutes := make(map[int]int)
+	for rows.Next() {
+		var id int
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			rows.Close()
+			return err
+		}
+		minutes[id] = readingMinutes(content)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, m := range minutes {
+		if _, err := tx.Exec(s.rebind(`UPDATE articles SET reading_minutes = ? WHERE id = ?`), m, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillArticleHosts fills in the host of articles saved before hosts were recorded
+func (s *sqlStore) backfillArticleHosts(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, article_link FROM articles WHERE host = ''`)
+	if err != nil {
+		return err
+	}
+	hosts := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var link string
+		if err := rows.Scan(&id, &link); err != nil {
+			rows.Close()
+			return err
+		}
+		if host := articleHost(link); host != "" {
+			hosts[id] = host
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, host := range hosts {
+		if _, err := tx.Exec(s.rebind(`UPDATE articles SET host = ? WHERE id = ?`), host, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backfillTitleKeys sets title_key on articles saved before it existed
+func (s *sqlStore) backfillTitleKeys(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, title FROM articles WHERE title_key = ''`)
+	if err != nil {
+		return err
+	}
+	keys := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var title string
+		if err := rows.Scan(&id, &title); err != nil {
+			rows.Close()
+			return err
+		}
+		if key := titleKey(title); key != "" {
+			keys[id] = key
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, key := range keys {
+		if _, err := tx.Exec(s.rebind(`UPDATE articles SET title_key = ? WHERE id = ?`), key, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execMigration returns a migration step that runs the given SQL
+func execMigration(query string) func(s *sqlStore, tx *sql.Tx) error {
+	return func(s *sqlStore, tx *sql.Tx) error {
+		_, err := tx.Exec(query)
+		return err
+	}
+}
+
+// addColumnMigration returns a migration step that adds a column if it's missing
+func addColumnMigration(table, column, definition string) func(s *sqlStore, tx *sql.Tx) error {
+	return func(s *sqlStore, tx *sql.Tx) error {
+		return addColumnIfMissing(tx, table, column, definition)
+	}
+}
+
+// runMigrations applies every migration not yet recorded in schema_migrations
+func (s *sqlStore) runMigrations(migrations []migration) error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := s.db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("failed to load applied migrations: %w", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to load applied migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load applied migrations: %w", err)
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		if err := s.applyMigration(m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		slog.Info("Applied migration", "version", m.version, "description", m.description)
+	}
+	return nil
+}
+
+// applyMigration runs one migration and records it in a single transaction
+func (s *sqlStore) applyMigration(m migration) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(s, tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(s.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), m.version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addColumnIfMissing adds a column to an existing table if it isn't already present
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	slog.Info("Added missing column", "table", table, "column", column)
+	return nil
+}
+
+// loadTemplates parses all HTML templates, only replacing the current set if
+// every template parses
+func loadTemplates() error {
+	parsed, err := template.ParseFS(assets, "templates/*.html")
+	if err != nil {
+		return fmt.Errorf("failed to load templates: %w", err)
+	}
+	templatesMu.Lock()
+	templates = parsed
+	templatesMu.Unlock()
+	return nil
+}
+
+// currentTemplates returns the templates to render with. In dev mode they're
+// re-parsed first, and if that fails the last good set is used.
+func currentTemplates() *template.Template {
+	if devMode {
+		if err := loadTemplates(); err != nil {
+			slog.Error("Failed to reload templates, using the previous version", "error", err)
+		}
+	}
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return templates
+}
+
+// renderTemplate executes a template into memory, so an error partway through
+// can still be answered with a clean 500 instead of a truncated page
+func renderTemplate(name string, data any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := currentTemplates().ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// getWithRetry performs a GET, retrying network errors and 5xx responses with
+// exponential backoff. 4xx responses are returned to the caller without retrying.
+func getWithRetry(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	delay := fetchRetryBaseDelay
+	var lastErr error
+
+	for attempt := 1; attempt <= fetchRetryAttempts; attempt++ {
+		if attempt > 1 {
+			slog.WarnContext(ctx, "Retrying request", "url", url, "attempt", attempt, "delay", delay, "error", lastErr)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			delay *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("User-Agent", userAgent)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("server returned status %d", resp.StatusCode)
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempts: %w", fetchRetryAttempts, lastErr)
+}
+
+// Keys used in the meta table
+const (
+	metaKeyLastSyncTime   = "last_sync_time"
+	metaKeyLastDigestSent = "last_digest_sent"
+)
+
+// GetMeta returns the value stored under key, or "" if unset
+func (s *sqlStore) GetMeta(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT value FROM meta WHERE key = ?`), key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetMeta stores value under key, replacing any previous value
+func (s *sqlStore) SetMeta(ctx context.Context, key, value string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`
+		INSERT INTO meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`), key, value)
+	return err
+}
+
+// loadLastSyncTime restores the persisted last sync time into memory
+func loadLastSyncTime(ctx context.Context) error {
+	value, err := store.GetMeta(ctx, metaKeyLastSyncTime)
+	if err != nil || value == "" {
+		return err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return fmt.Errorf("failed to parse stored last sync time %q: %w", value, err)
+	}
+
+	syncTimeMu.Lock()
+	lastSyncTime = t
+	syncTimeMu.Unlock()
+	return nil
+}
+
+// GetFeedMeta returns the stored ETag and Last-Modified values for a feed
+func (s *sqlStore) GetFeedMeta(ctx context.Context, feedURL string) (etag, lastModified string, err error) {
+	err = s.db.QueryRowContext(ctx, s.rebind(`SELECT etag, last_modified FROM feed_meta WHERE feed_url = ?`), feedURL).
+		Scan(&etag, &lastModified)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return etag, lastModified, err
+}
+
+// SaveFeedMeta stores the ETag and Last-Modified values and channel title from a feed response
+func (s *sqlStore) SaveFeedMeta(ctx context.Context, feedURL, etag, lastModified, title string) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(`
+		INSERT INTO feed_meta (feed_url, etag, last_modified, title) VALUES (?, ?, ?, ?)
+		ON CONFLICT(feed_url) DO UPDATE SET
+			etag = excluded.etag,
+			last_modified = excluded.last_modified,
+			title = excluded.title
+	`), feedURL, etag, lastModified, title)
+	return err
+}
+
+// GetFeedTitle returns the channel title last seen for a feed, or "" if it hasn't been fetched
+func (s *sqlStore) GetFeedTitle(ctx context.Context, feedURL string) (string, error) {
+	var title string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT title FROM feed_meta WHERE feed_url = ?`), feedURL).Scan(&title)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return title, err
+}
+
+// Maximum number of body bytes included in feed error messages
+const bodySnippetLength = 200
+
+// decodedBody returns a reader for the response body with any gzip or deflate
+// Content-Encoding removed. Closing it doesn't close resp.Body.
+func decodedBody(resp *http.Response) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "", "identity":
+		return io.NopCloser(resp.Body), nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(resp.Body)
+	case "deflate":
+		// Meant to be zlib-wrapped, but some servers send raw deflate. A zlib
+		// header has compression method 8 and is a multiple of 31.
+		br := bufio.NewReader(resp.Body)
+		hdr, _ := br.Peek(2)
+		if len(hdr) == 2 && hdr[0]&0x0f == 8 && (uint16(hdr[0])<<8|uint16(hdr[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+// bodySnippet returns the start of a response body for use in error messages
+func bodySnippet(body []byte) string {
+	if len(body) > bodySnippetLength {
+		return string(body[:bodySnippetLength]) + "..."
+	}
+	return string(body)
+}
+
+// isXMLContentType reports whether a Content-Type header could hold an RSS feed.
+// A missing header is given the benefit of the doubt.
+func isXMLContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	contentType = strings.ToLower(contentType)
+	return strings.Contains(contentType, "xml") ||
+		strings.Contains(contentType, "rss") ||
+		strings.Contains(contentType, "atom")
+}
+
+// fetchAndParseRSS fetches the RSS feed at feedURL and parses it.
+// It returns errFeedNotModified if the feed hasn't changed since the last fetch.
+// A dry run always fetches the whole feed and doesn't save its validators.
+func fetchAndParseRSS(ctx context.Context, feedURL string, dryRun bool) (_ *RSS, err error) {
+	ctx, span := tracer.Start(ctx, "fetch feed", trace.WithAttributes(attribute.String("feed.url", feedURL)))
+	defer func() {
+		// Not modified is a normal outcome rather than a failure
+		if errors.Is(err, errFeedNotModified) {
+			span.SetAttributes(attribute.Bool("feed.not_modified", true))
+			span.End()
+			return
+		}
+		endSpan(span, err)
+	}()
+
+	if u, err := url.Parse(feedURL); err == nil && u.Scheme == "file" {
+		return readRSSFile(ctx, feedURL, u.Path, dryRun)
+	}
+
+	header := http.Header{}
+	if !dryRun {
+		etag, lastModified, err := store.GetFeedMeta(ctx, feedURL)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to load feed metadata", "error", err, "feed", feedURL)
+		}
+		if etag != "" {
+			header.Set("If-None-Match", etag)
+		}
+		if lastModified != "" {
+			header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	// Setting this ourselves turns off the transport's own gzip handling, which
+	// doesn't cover deflate, so the body is decoded by decodedBody below
+	header.Set("Accept-Encoding", "gzip, deflate")
+
+	resp, err := getWithRetry(ctx, feedURL, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch RSS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, errFeedNotModified
+	}
+	reader, err := decodedBody(resp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress RSS body: %w", err)
+	}
+	defer reader.Close()
+	// Read one byte past the cap to tell a feed that's exactly at it from one that's over
+	body, err := io.ReadAll(io.LimitReader(reader, maxFeedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RSS body: %w", err)
+	}
+	if int64(len(body)) > maxFeedBytes {
+		return nil, fmt.Errorf("RSS feed is larger than the %d byte limit (MAX_FEED_BYTES)", maxFeedBytes)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("RSS feed returned status %d: %q", resp.StatusCode, bodySnippet(body))
+	}
+
+	// Error and captcha pages are usually HTML, so don't bother trying to parse them
+	contentType := resp.Header.Get("Content-Type")
+	if !isXMLContentType(contentType) {
+		return nil, fmt.Errorf("RSS feed returned unexpected content type %q (status %d): %q",
+			contentType, resp.StatusCode, bodySnippet(body))
+	}
+
+	var rss RSS
+	_, parseSpan := tracer.Start(ctx, "parse feed", trace.WithAttributes(attribute.Int("feed.bytes", len(body))))
+	err = xml.Unmarshal(body, &rss)
+	endSpan(parseSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse RSS (status %d, content type %q): %w: %q",
+			resp.StatusCode, contentType, err, bodySnippet(body))
+	}
+
+	// Only remember validators once the body parsed, so a bad response gets refetched
+	if !dryRun {
+		if err := store.SaveFeedMeta(ctx, feedURL, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), rss.Channel.Title); err != nil {
+			slog.WarnContext(ctx, "Failed to save feed metadata", "error", err, "feed", feedURL)
+		}
+	}
+
+	slog.InfoContext(ctx, "Successfully fetched RSS feed", "items", len(rss.Channel.Items))
+	return &rss, nil
+}
+
+// readRSSFile parses a feed saved on disk, for file:// feed URLs. There's no
+// conditional request to make, so the file is parsed on every sync.
+func readRSSFile(ctx context.Context, feedURL, path string, dryRun bool) (*RSS, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RSS file: %w", err)
+	}
+	defer f.Close()
+	body, err := io.ReadAll(io.LimitReader(f, maxFeedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read RSS file: %w", err)
+	}
+	if int64(len(body)) > maxFeedBytes {
+		return nil, fmt.Errorf("RSS feed is larger than the %d byte limit (MAX_FEED_BYTES)", maxFeedBytes)
+	}
+
+	var rss RSS
+	_, parseSpan := tracer.Start(ctx, "parse feed", trace.WithAttributes(attribute.Int("feed.bytes", len(body))))
+	err = xml.Unmarshal(body, &rss)
+	endSpan(parseSpan, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse RSS file %s: %w: %q", path, err, bodySnippet(body))
+	}
+
+	if !dryRun {
+		if err := store.SaveFeedMeta(ctx, feedURL, "", "", rss.Channel.Title); err != nil {
+			slog.WarnContext(ctx, "Failed to save feed metadata", "error", err, "feed", feedURL)
+		}
+	}
+
+	slog.InfoContext(ctx, "Successfully read RSS file", "path", path, "items", len(rss.Channel.Items))
+	return &rss, nil
+}
+
+// validateFeedURL checks that the feed URL is an absolute http(s) URL, or a
+// file:// URL with an absolute path for reading a saved feed
+func validateFeedURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse feed URL: %w", err)
+	}
+	if u.Scheme == "file" {
+		if (u.Host != "" && u.Host != "localhost") || !strings.HasPrefix(u.Path, "/") {
+			return fmt.Errorf("file feed URL must be an absolute path like file:///path/to/feed.rss")
+		}
+		return nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("feed URL must use http, https or file, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("feed URL is missing a host")
+	}
+	return nil
+}
+
+// parseFeedURLs splits a comma-separated list of feed URLs and validates each one
+func parseFeedURLs(raw string) ([]string, error) {
+	var urls []string
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if err := validateFeedURL(part); err != nil {
+			return nil, fmt.Errorf("invalid feed URL %q: %w", part, err)
+		}
+		urls = append(urls, part)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no feed URLs configured")
+	}
+	return urls, nil
+}
+
+// cleanTitle normalizes a story title to plain text. The HTML parser already decodes
+// one level of entities, but some feeds escape twice, which would otherwise leave
+// titles showing a literal "&amp;". Whitespace runs are collapsed to single spaces.
+func cleanTitle(raw string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(raw)), " ")
+}
+
+// parseArticlesFromDescription extracts article links from the CDATA description
+func parseArticlesFromDescription(description, date string, base *url.URL) []Article {
+	var articles []Article
+	publishedAt := parsePubDate(date)
+
+	nodes, err := html.ParseFragment(strings.NewReader(description), &html.Node{
+		Type:     html.ElementNode,
+		Data:     "body",
+		DataAtom: atom.Body,
+	})
+	if err != nil {
+		slog.Warn("Failed to parse feed description", "error", err)
+		return articles
+	}
+
+	// Each story is a <li> containing a storylink and a postlink span
+	var items []*html.Node
+	for _, n := range nodes {
+		items = append(items, findElements(n, func(n *html.Node) bool {
+			return n.DataAtom == atom.Li
+		})...)
+	}
+
+	for _, li := range items {
+		var articleLink, commentLink, title string
+
+		// Extract article link and title
+		if story := findFirst(li, func(n *html.Node) bool { return hasClass(n, "storylink") }); story != nil {
+			if a := findFirst(story, isAnchor); a != nil {
+				articleLink = resolveLink(base, getAttr(a, "href"))
+				title = cleanTitle(textContent(a))
+			}
+		}
+
+		// Extract comment link
+		if post := findFirst(li, func(n *html.Node) bool { return hasClass(n, "postlink") }); post != nil {
+			if a := findFirst(post, isAnchor); a != nil {
+				commentLink = resolveLink(base, getAttr(a, "href"))
+			}
+		}
+
+		if articleLink != "" && commentLink != "" && title != "" {
+			text := textContent(li)
+			articles = append(articles, Article{
+				Date:         date,
+				ArticleLink:  articleLink,
+				CommentLink:  commentLink,
+				Title:        title,
+				Points:       parseCount(pointsPattern, text),
+				CommentCount: parseCount(commentCountPattern, text),
+				CreatedAt:    publishedAt,
+			})
+		}
+	}
+
+	return articles
+}
+
+// FeedParser extracts articles from one feed item. Feeds differ in where they put
+// the story, so each kind of source gets its own implementation.
+type FeedParser interface {
+	Parse(item Item, date string) []Article
+}
+
+// resolveLink makes ref absolute against base, so relative links in a feed point
+// where the feed meant them to. Absolute links are returned as-is and links that
+// don't parse come back empty.
+func resolveLink(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if u.IsAbs() || base == nil {
+		return ref
+	}
+	return base.ResolveReference(u).String()
+}
+
+// articleHost returns the lowercased host of a link without any "www." prefix,
+// so www.github.com and github.com are grouped together. It's "" for links
+// without a host.
+func articleHost(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return ""
+	}
+	return normalizeHost(u.Hostname())
+}
+
+// Year HN appends to the titles of older stories, like "Some essay (2012)"
+var titleYearSuffix = regexp.MustCompile(`\s*[(\[]\d{4}[)\]]\s*$`)
+
+// titleKey normalizes a title for matching reposts: lowercased, without a
+// trailing year, with punctuation dropped and runs of spaces collapsed. So
+// "Ben & Jerry's Story (2019)" and "ben  jerrys story" have the same key.
+func titleKey(title string) string {
+	title = titleYearSuffix.ReplaceAllString(title, "")
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// normalizeHost lowercases a host and drops a leading "www."
+func normalizeHost(host string) string {
+	host = strings.ToLower(strings.TrimSpace(host))
+	return strings.TrimPrefix(host, "www.")
+}
+
+// daemonologyParser handles Hacker News Daily, where each item is a day whose
+// description holds an HTML list of stories with their discussion links.
+// Relative links are resolved against the item's own page.
+type daemonologyParser struct {
+	feedURL *url.URL
+}
+
+func (p daemonologyParser) Parse(item Item, date string) []Article {
+	base := p.feedURL
+	if link := resolveLink(p.feedURL, item.Link); link != "" {
+		if u, err := url.Parse(link); err == nil {
+			base = u
+		}
+	}
+	return parseArticlesFromDescription(item.Description, date, base)
+}
+
+// rssItemParser handles standard feeds with one story per item, like the Hacker
+// News front page feed. The discussion link comes from <comments> when present.
+// Relative links are resolved against the feed's URL.
+type rssItemParser struct {
+	feedURL *url.URL
+}
+
+func (p rssItemParser) Parse(item Item, date string) []Article {
+	title := cleanTitle(item.Title)
+	link := resolveLink(p.feedURL, item.Link)
+	if title == "" || link == "" {
+		return nil
+	}
+
+	commentLink := resolveLink(p.feedURL, item.Comments)
+	if commentLink == "" {
+		commentLink = link
+	}
+	return []Article{{
+		Date:        date,
+		ArticleLink: link,
+		CommentLink: commentLink,
+		Title:       title,
+		CreatedAt:   parsePubDate(date),
+	}}
+}
+
+// feedParserFor picks the parser for a feed. Hacker News Daily is recognized by
+// its host, or by the story list markup for mirrors and copies of it. Anything
+// else is treated as a standard feed.
+func feedParserFor(feedURL string, items []Item) FeedParser {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		u = nil
+	}
+	if u != nil && strings.HasSuffix(u.Hostname(), "daemonology.net") {
+		return daemonologyParser{feedURL: u}
+	}
+	for _, item := range items {
+		if strings.Contains(item.Description, "storylink") {
+			return daemonologyParser{feedURL: u}
+		}
+	}
+	return rssItemParser{feedURL: u}
+}
+
+// Patterns for the counts shown next to a story, e.g. "523 points" and "204 comments"
+var (
+	pointsPattern       = regexp.MustCompile(`(\d+)\s+points?`)
+	commentCountPattern = regexp.MustCompile(`(\d+)\s+comments?`)
+)
+
+// parseCount extracts the number captured by pattern from a story's text, returning 0 if absent
+func parseCount(pattern *regexp.Regexp, text string) int {
+	match := pattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0
+	}
+	count, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0
+	}
+	return count
+}
+
+// parsePubDate parses an RSS pubDate, falling back to the current time if it can't be parsed
+func parsePubDate(date string) time.Time {
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, strings.TrimSpace(date)); err == nil {
+			return t
+		}
+	}
+	slog.Warn("Unparseable pubDate, using current time", "date", date)
+	return time.Now()
+}
+
+// findElements returns all element nodes under n (inclusive) matching the predicate,
+// without descending into matched nodes
+func findElements(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	var found []*html.Node
+	if n.Type == html.ElementNode && match(n) {
+		return append(found, n)
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		found = append(found, findElements(c, match)...)
+	}
+	return found
+}
+
+// findFirst returns the first element node under n (exclusive) matching the predicate
+func findFirst(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && match(c) {
+			return c
+		}
+		if found := findFirst(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// isAnchor reports whether n is an <a> element with an href
+func isAnchor(n *html.Node) bool {
+	return n.DataAtom == atom.A && getAttr(n, "href") != ""
+}
+
+// hasClass reports whether n has the given class in its class attribute
+func hasClass(n *html.Node, class string) bool {
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// getAttr returns the value of the named attribute, or "" if absent
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+// textContent returns the concatenated text of n and its descendants
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+// saveArticle saves an article to the database and returns whether it was inserted
+func saveArticle(ctx context.Context, article Article) (bool, error) {
+	inserted, err := store.SaveArticles(ctx, []Article{article})
+	return len(inserted) > 0, err
+}
+
+// SaveArticles saves articles in a single transaction and returns the ones that
+// were newly inserted. Duplicates are skipped according to DEDUP_BY.
+func (s *sqlStore) SaveArticles(ctx context.Context, articles []Article) ([]Article, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	inserter, err := s.newArticleInserter(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	defer inserter.Close()
+
+	var inserted []Article
+	for _, article := range articles {
+		ok, err := inserter.insert(ctx, &article)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save article %q: %w", article.Title, err)
+		}
+		if ok {
+			inserted = append(inserted, article)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit articles: %w", err)
+	}
+	if len(inserted) > 0 || inserter.refreshed > 0 || inserter.seen > 0 {
+		invalidateHomeCache()
+	}
+	if inserter.refreshed > 0 {
+		slog.DebugContext(ctx, "Refreshed article counts", "count", inserter.refreshed)
+	}
+	if inserter.seen > 0 {
+		slog.DebugContext(ctx, "Hid articles matching ones already read", "mode", hideSeen, "count", inserter.seen)
+	}
+	return inserted, nil
+}
+
+// articleInserter inserts articles within a transaction using prepared statements
+type articleInserter struct {
+	insertStmt *sql.Stmt
+	// Only prepared when deduplicating by article link
+	lookupStmt *sql.Stmt
+	mergeStmt  *sql.Stmt
+	// Only prepared when REFRESH_COUNTS is on
+	refreshStmt *sql.Stmt
+	// Only prepared when HIDE_SEEN is on
+	seenStmt *sql.Stmt
+	// Number of existing articles whose counts were raised
+	refreshed int
+	// Number of new articles hidden by HIDE_SEEN
+	seen int
+}
+
+// newArticleInserter prepares the statements needed for the configured dedup mode
+func (s *sqlStore) newArticleInserter(ctx context.Context, tx *sql.Tx) (*articleInserter, error) {
+	ai := &articleInserter{}
+	var err error
+	ai.insertStmt, err = tx.PrepareContext(ctx, s.rebind(`
+		INSERT INTO articles (date, article_link, comment_link, title, source, points, comment_count, read, status, host, created_at, raw_item_id, title_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT DO NOTHING
+		RETURNING id
+	`))
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare insert: %w", err)
+	}
+	if hideSeen != hideSeenOff {
+		// An article that's already stored is left to the normal duplicate handling
+		ai.seenStmt, err = tx.PrepareContext(ctx, s.rebind(`
+			SELECT 1 FROM articles
+			WHERE title_key = ? AND read = 1
+				AND NOT EXISTS (SELECT 1 FROM articles WHERE article_link = ? AND comment_link = ?)
+			LIMIT 1
+		`))
+		if err != nil {
+			ai.Close()
+			return nil, fmt.Errorf("failed to prepare seen lookup: %w", err)
+		}
+	}
+	if dedupBy != dedupByLink {
+		if !refreshCounts {
+			return ai, nil
+		}
+		ai.refreshStmt, err = tx.PrepareContext(ctx, s.rebind(`
+			UPDATE articles SET
+				points = CASE WHEN points < ? THEN ? ELSE points END,
+				comment_count = CASE WHEN comment_count < ? THEN ? ELSE comment_count END
+			WHERE article_link = ? AND comment_link = ? AND (points < ? OR comment_count < ?)
+		`))
+		if err != nil {
+			ai.Close()
+			return nil, fmt.Errorf("failed to prepare refresh: %w", err)
+		}
+		return ai, nil
+	}
+
+	// The (article_link, comment_link) unique index also serves lookups by link alone
+	ai.lookupStmt, err = tx.PrepareContext(ctx, s.rebind(`SELECT id FROM articles WHERE article_link = ? ORDER BY id LIMIT 1`))
+	if err != nil {
+		ai.Close()
+		return nil, fmt.Errorf("failed to prepare lookup: %w", err)
+	}
+	ai.mergeStmt, err = tx.PrepareContext(ctx, s.rebind(`
+		UPDATE articles SET
+			points = CASE WHEN points < ? THEN ? ELSE points END,
+			comment_count = CASE WHEN comment_count < ? THEN ? ELSE comment_count END
+		WHERE id = ?
+	`))
+	if err != nil {
+		ai.Close()
+		return nil, fmt.Errorf("failed to prepare merge: %w", err)
+	}
+	return ai, nil
+}
+
+// Close releases the prepared statements
+func (ai *articleInserter) Close() {
+	for _, stmt := range []*sql.Stmt{ai.insertStmt, ai.lookupStmt, ai.mergeStmt, ai.refreshStmt, ai.seenStmt} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// insert saves one article, keeping its read flag, and returns whether it was new.
+// New articles get their ID and creation time filled in. When deduplicating by
+// link, a story that's already stored keeps its first comment link and just picks
+// up any higher points or comment count. REFRESH_COUNTS does the same for exact
+// duplicates. Read, starred and created_at are never touched for existing rows.
+// With HIDE_SEEN, an article whose title matches one already read is saved as
+// read or skipped, and isn't reported as new either way.
+func (ai *articleInserter) insert(ctx context.Context, a *Article) (bool, error) {
+	if ai.lookupStmt != nil {
+		var existingID int
+		err := ai.lookupStmt.QueryRowContext(ctx, a.ArticleLink).Scan(&existingID)
+		if err == nil {
+			_, err = ai.mergeStmt.ExecContext(ctx, a.Points, a.Points, a.CommentCount, a.CommentCount, existingID)
+			return false, err
+		}
+		if err != sql.ErrNoRows {
+			return false, err
+		}
+	}
+
+	createdAt := a.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	createdAt = createdAt.UTC().Truncate(time.Second)
+	readInt := 0
+	status := articleStatusNew
+	if a.Read {
+		readInt = 1
+		status = articleStatusRead
+	} else if a.Status == articleStatusLater {
+		status = articleStatusLater
+	}
+	host := articleHost(a.ArticleLink)
+	key := titleKey(a.Title)
+	seen := false
+	if ai.seenStmt != nil && key != "" && !a.Read {
+		var one int
+		err := ai.seenStmt.QueryRowContext(ctx, key, a.ArticleLink, a.CommentLink).Scan(&one)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		seen = err == nil
+	}
+	if seen {
+		if hideSeen == hideSeenSkip {
+			ai.seen++
+			return false, nil
+		}
+		readInt = 1
+		status = articleStatusRead
+	}
+	var rawItemID sql.NullInt64
+	if a.rawItemID != 0 {
+		rawItemID = sql.NullInt64{Int64: int64(a.rawItemID), Valid: true}
+	}
+
+	// Conflicting rows aren't returned, so no rows means the article already existed
+	var id int
+	err := ai.insertStmt.QueryRowContext(ctx, a.Date, a.ArticleLink, a.CommentLink, a.Title, a.Source,
+		a.Points, a.CommentCount, readInt, status, host, createdAt.Format(sqliteTimeFormat), rawItemID, key).Scan(&id)
+	if err == sql.ErrNoRows {
+		if ai.refreshStmt == nil {
+			return false, nil
+		}
+		res, err := ai.refreshStmt.ExecContext(ctx, a.Points, a.Points, a.CommentCount, a.CommentCount,
+			a.ArticleLink, a.CommentLink, a.Points, a.CommentCount)
+		if err != nil {
+			return false, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			ai.refreshed++
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if seen {
+		ai.seen++
+		return false, nil
+	}
+	a.ID = id
+	a.CreatedAt = createdAt
+	a.Status = status
+	a.Host = host
+	return true, nil
+}
+
+// syncLimiter allows at most one event per interval across all clients. It's a
+// token bucket holding a single token, so there are no bursts to tune.
+type syncLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+// Limits manual syncs from /sync, configured from SYNC_RATE_LIMIT
+var manualSyncLimiter = &syncLimiter{}
+
+// Longest a sync may run before it's abandoned, configured from SYNC_TIMEOUT
+var syncTimeout = 2 * time.Minute
+
+// allow takes the token if it's available at now, otherwise it returns how long
+// until it will be. A zero interval allows everything.
+func (l *syncLimiter) allow(now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.interval <= 0 {
+		return true, 0
+	}
+	if wait := l.last.Add(l.interval).Sub(now); !l.last.IsZero() && wait > 0 {
+		return false, wait
+	}
+	l.last = now
+	return true, 0
+}
+
+// SyncStats counts what a sync did with the feed items it fetched. Items that
+// no article could be parsed from are counted as parse errors, and parsed
+// articles that were already stored, or hidden by HIDE_SEEN, as duplicates.
+type SyncStats struct {
+	ItemsFetched      int `json:"items_fetched"`
+	ArticlesParsed    int `json:"articles_parsed"`
+	NewArticles       int `json:"new_articles"`
+	SkippedDuplicates int `json:"skipped_duplicates"`
+	ParseErrors       int `json:"parse_errors"`
+}
+
+// add adds the counts of another feed to s
+func (s *SyncStats) add(other SyncStats) {
+	s.ItemsFetched += other.ItemsFetched
+	s.ArticlesParsed += other.ArticlesParsed
+	s.NewArticles += other.NewArticles
+	s.SkippedDuplicates += other.SkippedDuplicates
+	s.ParseErrors += other.ParseErrors
+}
+
+// logAttrs returns the counts as slog key-value pairs
+func (s SyncStats) logAttrs() []any {
+	return []any{
+		"items_fetched", s.ItemsFetched,
+		"articles_parsed", s.ArticlesParsed,
+		"new_articles", s.NewArticles,
+		"skipped_duplicates", s.SkippedDuplicates,
+		"parse_errors", s.ParseErrors,
+	}
+}
+
+// SyncStatus describes the most recent sync, as reported by /sync/status
+type SyncStatus struct {
+	Running     bool       `json:"running"`
+	LastSuccess *time.Time `json:"last_success"`
+	LastStarted *time.Time `json:"last_started"`
+	Outcome     string     `json:"outcome,omitempty"`
+	Duration    string     `json:"duration,omitempty"`
+	SyncStats
+	Error string `json:"error,omitempty"`
+}
+
+// Outcome of the last finished sync, since startup
+var (
+	lastSyncStatus SyncStatus
+	syncStatusMu   sync.Mutex
+)
+
+// syncOutcome describes how a sync that returned err ended
+func syncOutcome(err error) string {
+	switch {
+	case err == nil:
+		return "complete"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	default:
+		return "completed with errors"
+	}
+}
+
+// recordSyncStatus saves the outcome of a sync that started at start, both as
+// the current status and as a row in the sync history
+func recordSyncStatus(ctx context.Context, start time.Time, stats SyncStats, err error) {
+	duration := time.Since(start)
+	status := SyncStatus{
+		LastStarted: &start,
+		Outcome:     syncOutcome(err),
+		Duration:    duration.Round(time.Millisecond).String(),
+		SyncStats:   stats,
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	syncStatusMu.Lock()
+	lastSyncStatus = status
+	syncStatusMu.Unlock()
+
+	run := SyncRun{
+		StartedAt:   start,
+		DurationMS:  duration.Milliseconds(),
+		NewArticles: stats.NewArticles,
+		Success:     err == nil,
+		Outcome:     status.Outcome,
+		Error:       status.Error,
+	}
+	// A cancelled or timed out sync is still worth a history row
+	if err := store.SaveSyncRun(context.WithoutCancel(ctx), run); err != nil {
+		slog.ErrorContext(ctx, "Failed to save sync history", "error", err)
+	}
+}
+
+// SyncRun is one sync in the history returned by /api/sync-history
+type SyncRun struct {
+	ID          int       `json:"id"`
+	StartedAt   time.Time `json:"started_at"`
+	DurationMS  int64     `json:"duration_ms"`
+	NewArticles int       `json:"new_articles"`
+	Success     bool      `json:"success"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Number of runs returned by /api/sync-history when no limit is given, and the most allowed
+const (
+	defaultSyncHistoryLimit = 50
+	maxSyncHistoryLimit     = 1000
+)
+
+// SaveSyncRun adds a finished sync to the history
+func (s *sqlStore) SaveSyncRun(ctx context.Context, run SyncRun) error {
+	success := 0
+	if run.Success {
+		success = 1
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(`
+		INSERT INTO sync_runs (started_at, duration_ms, new_articles, success, outcome, error)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`), run.StartedAt.UTC().Format(sqliteTimeFormat), run.DurationMS, run.NewArticles, success, run.Outcome, run.Error)
+	return err
+}
+
+// SyncRuns returns the most recent syncs, newest first
+func (s *sqlStore) SyncRuns(ctx context.Context, limit int) ([]SyncRun, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT id, started_at, duration_ms, new_articles, success, outcome, error
+		FROM sync_runs
+		ORDER BY started_at DESC, id DESC
+		LIMIT ?
+	`), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []SyncRun
+	for rows.Next() {
+		var run SyncRun
+		var success int
+		if err := rows.Scan(&run.ID, &run.StartedAt, &run.DurationMS, &run.NewArticles, &success, &run.Outcome, &run.Error); err != nil {
+			return nil, err
+		}
+		run.Success = success == 1
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// currentSyncStatus returns the last sync's outcome along with whether one is
+// running now and when the last successful one finished
+func currentSyncStatus() SyncStatus {
+	syncStatusMu.Lock()
+	status := lastSyncStatus
+	syncStatusMu.Unlock()
+
+	status.Running = syncRunning.Load()
+	syncTimeMu.RLock()
+	if !lastSyncTime.IsZero() {
+		t := lastSyncTime
+		status.LastSuccess = &t
+	}
+	syncTimeMu.RUnlock()
+	return status
+}
+
+// tryStartSync claims the sync slot, returning false if a sync is already running.
+// Callers that get true must call finishSync when done.
+func tryStartSync() bool {
+	return syncRunning.CompareAndSwap(false, true)
+}
+
+// finishSync releases the sync slot claimed by tryStartSync
+func finishSync() {
+	syncRunning.Store(false)
+}
+
+// ImportArticles inserts articles from a backup in a single transaction, keeping
+// their read flag. Articles that already exist are skipped, as in SaveArticles.
+func (s *sqlStore) ImportArticles(ctx context.Context, articles []Article) (inserted, skipped int, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin import: %w", err)
+	}
+	defer tx.Rollback()
+
+	inserter, err := s.newArticleInserter(ctx, tx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer inserter.Close()
+
+	for _, a := range articles {
+		ok, err := inserter.insert(ctx, &a)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to import article %q: %w", a.Title, err)
+		}
+		if ok {
+			inserted++
+		} else {
+			skipped++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit import: %w", err)
+	}
+	if inserted > 0 {
+		invalidateHomeCache()
+	}
+	return inserted, skipped, nil
+}
+
+// validateImportedArticle checks that an article from a backup has the required fields
+func validateImportedArticle(a Article) error {
+	if strings.TrimSpace(a.Title) == "" {
+		return fmt.Errorf("title is required")
+	}
+	if strings.TrimSpace(a.ArticleLink) == "" {
+		return fmt.Errorf("article_link is required")
+	}
+	if strings.TrimSpace(a.CommentLink) == "" {
+		return fmt.Errorf("comment_link is required")
+	}
+	return nil
+}
+
+// PruneArticles archives or deletes read articles last touched before the cutoff.
+// Starred articles are always kept. It returns the number of articles removed.
+func (s *sqlStore) PruneArticles(ctx context.Context, cutoff time.Time, mode string) (int64, error) {
+	const condition = `read = 1 AND starred = 0 AND COALESCE(read_at, created_at) < ?`
+	cutoffStr := cutoff.UTC().Format(sqliteTimeFormat)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if mode == pruneModeArchive {
+		_, err := tx.ExecContext(ctx, s.rebind(`
+			INSERT INTO archived_articles
+				(id, date, article_link, comment_link, title, source, points, comment_count, read_at, created_at)
+			SELECT id, date, article_link, comment_link, title, source, points, comment_count, read_at, created_at
+			FROM articles
+			WHERE `+condition+`
+			ON CONFLICT (id) DO UPDATE SET
+				date = excluded.date,
+				article_link = excluded.article_link,
+				comment_link = excluded.comment_link,
+				title = excluded.title,
+				source = excluded.source,
+				points = excluded.points,
+				comment_count = excluded.comment_count,
+				read_at = excluded.read_at,
+				created_at = excluded.created_at,
+				archived_at = CURRENT_TIMESTAMP
+		`), cutoffStr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to archive articles: %w", err)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM articles WHERE `+condition), cutoffStr)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete articles: %w", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if pruned > 0 {
+		invalidateHomeCache()
+	}
+	return pruned, nil
+}
+
+// runPrune prunes articles older than the retention period and logs the outcome
+func runPrune(ctx context.Context, retentionDays int, mode string) {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	pruned, err := store.PruneArticles(ctx, cutoff, mode)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error pruning old articles", "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Pruned old read articles", "mode", mode, "retention_days", retentionDays, "articles", pruned)
+}
+
+// VacuumResult is the response to /admin/vacuum. Sizes are in bytes.
+type VacuumResult struct {
+	SizeBefore int64 `json:"size_before"`
+	SizeAfter  int64 `json:"size_after"`
+	Reclaimed  int64 `json:"reclaimed"`
+	DurationMS int64 `json:"duration_ms"`
+}
+
+// dbSize returns the size of the SQLite database file, not counting the WAL
+func (s *sqliteStore) dbSize(ctx context.Context) (int64, error) {
+	var size int64
+	err := s.db.QueryRowContext(ctx, `SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size)
+	return size, err
+}
+
+// Vacuum rebuilds the SQLite database to give the space freed by pruning back
+// to the filesystem. It locks the database while it runs, so callers hold the
+// sync slot.
+func (s *sqliteStore) Vacuum(ctx context.Context) (VacuumResult, error) {
+	start := time.Now()
+	before, err := s.dbSize(ctx)
+	if err != nil {
+		return VacuumResult{}, err
+	}
+	if _, err := s.db.ExecContext(ctx, `VACUUM`); err != nil {
+		return VacuumResult{}, err
+	}
+	// In WAL mode the file only shrinks once the rebuilt pages are checkpointed
+	if _, err := s.db.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return VacuumResult{}, err
+	}
+	after, err := s.dbSize(ctx)
+	if err != nil {
+		return VacuumResult{}, err
+	}
+
+	result := VacuumResult{
+		SizeBefore: before,
+		SizeAfter:  after,
+		Reclaimed:  before - after,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	slog.InfoContext(ctx, "Vacuumed database", "size_before", before, "size_after", after,
+		"reclaimed", result.Reclaimed, "duration", time.Since(start))
+	return result, nil
+}
+
+// runVacuum vacuums the database after a prune, skipping it if a sync is running
+func runVacuum(ctx context.Context) {
+	if !tryStartSync() {
+		slog.InfoContext(ctx, "Skipping vacuum, sync already running")
+		return
+	}
+	defer finishSync()
+
+	if _, err := store.Vacuum(ctx); err != nil {
+		slog.ErrorContext(ctx, "Error vacuuming database", "error", err)
+	}
+}
+
+// runRawItemPrune deletes raw feed items not seen in the last retentionDays days.
+// It runs even with STORE_RAW_ITEMS off so turning it off frees the space.
+func runRawItemPrune(ctx context.Context, retentionDays int) {
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	pruned, err := store.PruneRawItems(ctx, cutoff)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error pruning raw feed items", "error", err)
+		return
+	}
+	slog.InfoContext(ctx, "Pruned old raw feed items", "retention_days", retentionDays, "items", pruned)
+}
+
+// processFeed fetches and processes every configured RSS feed, returning how many
+// articles were new. A failing feed doesn't stop the others, its error is joined
+// into the returned one. The whole sync is abandoned after syncTimeout so a hung
+// feed can't hold up the next one. Follow-up work for the new articles runs in
+// the background.
+func processFeed(ctx context.Context) (stats SyncStats, err error) {
+	ctx, span := tracer.Start(ctx, "sync feeds", trace.WithAttributes(attribute.Int("feeds", len(feedURLs))))
+	defer func() {
+		span.SetAttributes(attribute.Int("new_articles", stats.NewArticles))
+		endSpan(span, err)
+	}()
+
+	slog.InfoContext(ctx, "Starting RSS feed processing", "feeds", len(feedURLs))
+	feedSyncsTotal.Inc()
+	start := time.Now()
+	defer func() { recordSyncStatus(ctx, start, stats, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+
+	var newArticles []Article
+	var feedErrs []error
+	for _, feedURL := range feedURLs {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// A failing feed is logged and skipped so the others still sync
+		inserted, feedStats, err := processSingleFeed(ctx, feedURL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error fetching RSS", "error", err, "feed", feedURL)
+			feedSyncFailuresTotal.Inc()
+			feedErrs = append(feedErrs, fmt.Errorf("%s: %w", feedURL, err))
+			continue
+		}
+		newArticles = append(newArticles, inserted...)
+		stats.add(feedStats)
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		slog.ErrorContext(ctx, "Feed processing timed out", append([]any{"timeout", syncTimeout}, stats.logAttrs()...)...)
+		return stats, ctx.Err()
+	}
+	if ctx.Err() != nil {
+		slog.InfoContext(ctx, "Feed processing cancelled", stats.logAttrs()...)
+		return stats, ctx.Err()
+	}
+
+	// A sync where every feed failed fetched nothing, so it doesn't count as the
+	// last successful one
+	if len(feedErrs) < len(feedURLs) {
+		now := time.Now()
+		syncTimeMu.Lock()
+		lastSyncTime = now
+		syncTimeMu.Unlock()
+		invalidateHomeCache()
+
+		if err := store.SetMeta(ctx, metaKeyLastSyncTime, now.Format(time.RFC3339Nano)); err != nil {
+			slog.ErrorContext(ctx, "Error saving last sync time", "error", err)
+		}
+	}
+
+	// These outlive the sync, which may be tied to a request
+	if contentFetchEnabled && len(newArticles) > 0 {
+		runInBackground(func() { fetchPendingContent(backgroundCtx) })
+	}
+	if digestEnabled {
+		runInBackground(func() { sendDigest(backgroundCtx, newArticles) })
+	}
+	if webhookURL != "" && len(newArticles) > 0 {
+		runInBackground(func() { sendWebhook(backgroundCtx, newArticles) })
+	}
+
+	articlesInsertedTotal.Add(float64(len(newArticles)))
+	lastSyncNewArticles.Set(float64(len(newArticles)))
+	refreshUnreadGauge(ctx)
+
+	slog.InfoContext(ctx, "Feed processing complete", stats.logAttrs()...)
+	return stats, errors.Join(feedErrs...)
+}
+
+// parseFeedArticles extracts the articles from every item of a feed, oldest
+// first, along with the number of items that gave no articles
+func parseFeedArticles(feedURL string, rss *RSS, parser FeedParser) (articles []Article, failed int) {
+	for i := len(rss.Channel.Items) - 1; i >= 0; i-- {
+		// Process items in reverse order to maintain chronological order
+		item := rss.Channel.Items[i]
+		rawItem := encodeRawItem(item)
+		parsed := parser.Parse(item, item.PubDate)
+		if len(parsed) == 0 {
+			failed++
+		}
+		for _, article := range parsed {
+			article.Source = feedURL
+			article.rawItem = rawItem
+			articles = append(articles, article)
+		}
+	}
+	return articles, failed
+}
+
+// Whether each sync's feed items are kept in raw_items, set from STORE_RAW_ITEMS
+var storeRawItems = true
+
+// encodeRawItem returns the JSON a feed item is stored as
+func encodeRawItem(item Item) string {
+	b, err := json.Marshal(item)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// rawItemHash identifies a stored item's contents, so an item that hasn't
+// changed since the last sync isn't stored again
+func rawItemHash(rawItem string) string {
+	sum := sha256.Sum256([]byte(rawItem))
+	return hex.EncodeToString(sum[:])
+}
+
+// upsertRawItemQuery saves a raw item, or marks an identical one as seen again,
+// and returns its ID either way
+const upsertRawItemQuery = `
+	INSERT INTO raw_items (feed_url, item_hash, pub_date, item) VALUES (?, ?, ?, ?)
+	ON CONFLICT(feed_url, item_hash) DO UPDATE SET last_seen = CURRENT_TIMESTAMP
+	RETURNING id`
+
+// SaveRawItems stores every item of a feed, including ones no article was
+// parsed from, and links the parsed articles to their items
+func (s *sqlStore) SaveRawItems(ctx context.Context, feedURL string, items []Item, articles []Article) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, s.rebind(upsertRawItemQuery))
+	if err != nil {
+		return fmt.Errorf("failed to prepare raw item insert: %w", err)
+	}
+	defer stmt.Close()
+
+	ids := make(map[string]int, len(items))
+	for _, item := range items {
+		rawItem := encodeRawItem(item)
+		if rawItem == "" {
+			continue
+		}
+		if _, ok := ids[rawItem]; ok {
+			continue
+		}
+		var id int
+		if err := stmt.QueryRowContext(ctx, feedURL, rawItemHash(rawItem), item.PubDate, rawItem).Scan(&id); err != nil {
+			return fmt.Errorf("failed to save raw item: %w", err)
+		}
+		ids[rawItem] = id
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit raw items: %w", err)
+	}
+
+	for i := range articles {
+		articles[i].rawItemID = ids[articles[i].rawItem]
+	}
+	return nil
+}
+
+// PruneRawItems deletes raw items that haven't been in a feed since cutoff and
+// unlinks the articles that pointed at them, returning how many were deleted
+func (s *sqlStore) PruneRawItems(ctx context.Context, cutoff time.Time) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.rebind(`DELETE FROM raw_items WHERE last_seen < ?`), cutoff.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE articles SET raw_item_id = NULL
+		WHERE raw_item_id IS NOT NULL AND raw_item_id NOT IN (SELECT id FROM raw_items)`)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ReparseResult is the response to /admin/reparse
+type ReparseResult struct {
+	Items     int `json:"items"`
+	Articles  int `json:"articles"`
+	Updated   int `json:"updated"`
+	Unmatched int `json:"unmatched"`
+	Failed    int `json:"failed"`
+}
+
+// storedArticle is the part of a stored article reparsing compares against
+type storedArticle struct {
+	id          int
+	articleLink string
+	commentLink string
+}
+
+// ReparseArticles runs the current parsers over the raw items articles were
+// parsed from and updates any article whose title, links or counts now parse
+// differently. Parsed articles are matched to stored ones by discussion link,
+// then story link, or directly when an item only ever held one story. Articles
+// without a raw item are left alone, and counts only go up, as with
+// REFRESH_COUNTS.
+func (s *sqlStore) ReparseArticles(ctx context.Context) (ReparseResult, error) {
+	var result ReparseResult
+
+	type itemKey struct{ source, rawItem string }
+	groups := make(map[itemKey][]storedArticle)
+	var order []itemKey
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT articles.id, articles.source, raw_items.item, articles.article_link, articles.comment_link
+		FROM articles JOIN raw_items ON raw_items.id = articles.raw_item_id
+		ORDER BY articles.id
+	`)
+	if err != nil {
+		return result, err
+	}
+	for rows.Next() {
+		var key itemKey
+		var a storedArticle
+		if err := rows.Scan(&a.id, &key.source, &key.rawItem, &a.articleLink, &a.commentLink); err != nil {
+			rows.Close()
+			return result, err
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for _, key := range order {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		stored := groups[key]
+		result.Items++
+		result.Articles += len(stored)
+
+		var item Item
+		if err := json.Unmarshal([]byte(key.rawItem), &item); err != nil {
+			slog.WarnContext(ctx, "Failed to decode saved feed item", "error", err, "feed", key.source)
+			result.Failed += len(stored)
+			continue
+		}
+		parsed := feedParserFor(key.source, []Item{item}).Parse(item, item.PubDate)
+
+		for _, a := range stored {
+			match, ok := matchParsedArticle(a, stored, parsed)
+			if !ok {
+				result.Unmatched++
+				continue
+			}
+			updated, err := s.applyReparsedArticle(ctx, a.id, match)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to update reparsed article", "error", err, "id", a.id)
+				result.Failed++
+				continue
+			}
+			if updated {
+				result.Updated++
+			}
+		}
+	}
+
+	if result.Updated > 0 {
+		invalidateHomeCache()
+	}
+	return result, nil
+}
+
+// matchParsedArticle finds the freshly parsed article that a stored one came from
+func matchParsedArticle(a storedArticle, stored []storedArticle, parsed []Article) (Article, bool) {
+	for _, p := range parsed {
+		if p.CommentLink == a.commentLink {
+			return p, true
+		}
+	}
+	for _, p := range parsed {
+		if p.ArticleLink == a.articleLink {
+			return p, true
+		}
+	}
+	// A fix to both links can still be matched when there's no ambiguity
+	if len(stored) == 1 && len(parsed) == 1 {
+		return parsed[0], true
+	}
+	return Article{}, false
+}
+
+// applyReparsedArticle updates a stored article to match how it parses now, and
+// reports whether anything changed
+func (s *sqlStore) applyReparsedArticle(ctx context.Context, id int, a Article) (bool, error) {
+	result, err := s.db.ExecContext(ctx, s.rebind(`
+		UPDATE articles SET
+			title = ?,
+			article_link = ?,
+			comment_link = ?,
+			host = ?,
+			points = CASE WHEN points < ? THEN ? ELSE points END,
+			comment_count = CASE WHEN comment_count < ? THEN ? ELSE comment_count END
+		WHERE id = ? AND (title != ? OR article_link != ? OR comment_link != ? OR points < ? OR comment_count < ?)
+	`), a.Title, a.ArticleLink, a.CommentLink, articleHost(a.ArticleLink),
+		a.Points, a.Points, a.CommentCount, a.CommentCount,
+		id, a.Title, a.ArticleLink, a.CommentLink, a.Points, a.CommentCount)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// DryRunFeed is what a dry run found in one feed
+type DryRunFeed struct {
+	Feed     string    `json:"feed"`
+	Parser   string    `json:"parser,omitempty"`
+	Items    int       `json:"items"`
+	Count    int       `json:"count"`
+	Articles []Article `json:"articles"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// DryRunResult is the response to /sync/dry-run
+type DryRunResult struct {
+	Feeds []DryRunFeed `json:"feeds"`
+	Count int          `json:"count"`
+}
+
+// parserName names a feed parser for dry run output
+func parserName(parser FeedParser) string {
+	switch parser.(type) {
+	case daemonologyParser:
+		return "daemonology"
+	case rssItemParser:
+		return "rss"
+	default:
+		return ""
+	}
+}
+
+// dryRunFeeds fetches and parses every configured feed without saving anything,
+// for checking what the parsers make of the current markup
+func dryRunFeeds(ctx context.Context) DryRunResult {
+	result := DryRunResult{Feeds: []DryRunFeed{}}
+	for _, feedURL := range feedURLs {
+		feed := DryRunFeed{Feed: feedURL, Articles: []Article{}}
+		rss, err := fetchAndParseRSS(ctx, feedURL, true)
+		if err != nil {
+			feed.Error = err.Error()
+			result.Feeds = append(result.Feeds, feed)
+			continue
+		}
+
+		parser := feedParserFor(feedURL, rss.Channel.Items)
+		feed.Parser = parserName(parser)
+		feed.Items = len(rss.Channel.Items)
+		articles, _ := parseFeedArticles(feedURL, rss, parser)
+		for _, a := range articles {
+			a.Host = articleHost(a.ArticleLink)
+			feed.Articles = append(feed.Articles, a)
+		}
+		feed.Count = len(feed.Articles)
+		result.Count += feed.Count
+		result.Feeds = append(result.Feeds, feed)
+	}
+	return result
+}
+
+// processSingleFeed fetches one feed and saves its articles, returning the new ones
+func processSingleFeed(ctx context.Context, feedURL string) (newArticles []Article, stats SyncStats, err error) {
+	ctx, span := tracer.Start(ctx, "process feed", trace.WithAttributes(attribute.String("feed.url", feedURL)))
+	defer func() {
+		span.SetAttributes(attribute.Int("new_articles", len(newArticles)))
+		endSpan(span, err)
+	}()
+
+	rss, err := fetchAndParseRSS(ctx, feedURL, false)
+	if errors.Is(err, errFeedNotModified) {
+		slog.InfoContext(ctx, "Feed not modified since last sync, skipping", "feed", feedURL)
+		return nil, SyncStats{}, nil
+	}
+	if err != nil {
+		return nil, SyncStats{}, err
+	}
+
+	parser := feedParserFor(feedURL, rss.Channel.Items)
+	_, parseSpan := tracer.Start(ctx, "extract articles", trace.WithAttributes(attribute.String("feed.parser", parserName(parser))))
+	articles, failed := parseFeedArticles(feedURL, rss, parser)
+	parseSpan.SetAttributes(attribute.Int("articles", len(articles)), attribute.Int("parse_errors", failed))
+	parseSpan.End()
+
+	// Raw items are a debugging aid, so failing to save them doesn't fail the sync
+	if storeRawItems {
+		if err := store.SaveRawItems(ctx, feedURL, rss.Channel.Items, articles); err != nil {
+			slog.WarnContext(ctx, "Failed to save raw feed items", "error", err, "feed", feedURL)
+		}
+	}
+
+	newArticles, err = store.SaveArticles(ctx, articles)
+	if err != nil {
+		return nil, SyncStats{}, err
+	}
+
+	stats = SyncStats{
+		ItemsFetched:      len(rss.Channel.Items),
+		ArticlesParsed:    len(articles),
+		NewArticles:       len(newArticles),
+		SkippedDuplicates: len(articles) - len(newArticles),
+		ParseErrors:       failed,
+	}
+	slog.InfoContext(ctx, "Feed processed", append([]any{"feed", feedURL}, stats.logAttrs()...)...)
+	return newArticles, stats, nil
+}
+
+// CheckHealth verifies the database is reachable and the articles table is queryable
+func (s *sqlStore) CheckHealth(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("database ping failed: %w", err)
+	}
+
+	var one int
+	if err := s.db.QueryRowContext(ctx, s.rebind(`SELECT 1 FROM articles LIMIT 1`)).Scan(&one); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("articles query failed: %w", err)
+	}
+	return nil
+}
+
+// setDBHealth records the result of a health check, logging when the database
+// goes down or comes back
+func setDBHealth(ctx context.Context, err error) {
+	dbHealth.mu.Lock()
+	defer dbHealth.mu.Unlock()
+
+	healthy := err == nil
+	if err != nil {
+		dbHealth.lastErr = err.Error()
+		dbUpGauge.Set(0)
+	} else {
+		dbHealth.lastErr = ""
+		dbUpGauge.Set(1)
+	}
+	if healthy == dbHealth.healthy && !dbHealth.since.IsZero() {
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case dbHealth.since.IsZero():
+	case healthy:
+		slog.InfoContext(ctx, "Database is healthy again", "down_for", now.Sub(dbHealth.since).Round(time.Second))
+	default:
+		slog.ErrorContext(ctx, "Database is unhealthy", "error", err)
+	}
+	dbHealth.healthy = healthy
+	dbHealth.since = now
+}
+
+// resetDBPool closes idle connections so the next queries open fresh ones, which
+// picks the database file up again after something like a volume remount.
+// Connections in use are closed when they're returned. An in-memory database
+// is never reset since closing its connection would lose it.
+func resetDBPool(ctx context.Context) {
+	if dbInMemory {
+		return
+	}
+	slog.DebugContext(ctx, "Reopening database connections")
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(dbMaxIdleConns)
+}
+
+// monitorDB checks the database every dbMonitorInterval. While it's failing the
+// pool is reset and the check retried with exponential backoff, up to the
+// normal interval. Healthy checks are silent.
+func monitorDB(ctx context.Context) {
+	delay := dbReconnectBaseDelay
+	for {
+		err := store.CheckHealth(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		setDBHealth(ctx, err)
+
+		wait := dbMonitorInterval
+		if err != nil {
+			resetDBPool(ctx)
+			wait = delay
+			delay = min(delay*2, dbMonitorInterval)
+		} else {
+			delay = dbReconnectBaseDelay
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notSnoozed is a WHERE condition leaving out articles snoozed past the time
+// given as its argument, see snoozeNow
+const notSnoozed = "(snoozed_until IS NULL OR snoozed_until <= ?)"
+
+// snoozeNow returns the current time as the argument for notSnoozed
+func snoozeNow() string {
+	return time.Now().UTC().Format(sqliteTimeFormat)
+}
+
+// UnreadCount returns the count of unread articles, not counting snoozed ones
+func (s *sqlStore) UnreadCount(ctx context.Context) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM articles WHERE status = 'new' AND `+notSnoozed), snoozeNow()).Scan(&count)
+	return count, err
+}
+
+// UnreadArticleIDs returns the IDs of every unread, unsnoozed article in the default list order
+func (s *sqlStore) UnreadArticleIDs(ctx context.Context) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT id FROM articles WHERE status = 'new' AND `+notSnoozed+` ORDER BY `+articleSortOrders[defaultSort]), snoozeNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// articleColumns is the column list expected by scanArticles
+const articleColumns = `id, date, article_link, comment_link, title, source, points, comment_count, read, created_at, read_at, status, host, starred, reading_minutes, snoozed_until,
+	(SELECT string_agg(tags.name, ',') FROM article_tags JOIN tags ON tags.id = article_tags.tag_id
+		WHERE article_tags.article_id = articles.id)`
+
+// scanArticle reads the current row, selected with articleColumns, into an article
+func scanArticle(rows *sql.Rows) (Article, error) {
+	var a Article
+	var readInt int
+	var readAt sql.NullTime
+	var starredInt int
+	var minutes sql.NullInt64
+	var snoozedUntil sql.NullTime
+	var tags sql.NullString
+	err := rows.Scan(&a.ID, &a.Date, &a.ArticleLink, &a.CommentLink, &a.Title, &a.Source, &a.Points, &a.CommentCount,
+		&readInt, &a.CreatedAt, &readAt, &a.Status, &a.Host, &starredInt, &minutes, &snoozedUntil, &tags)
+	if err != nil {
+		return Article{}, err
+	}
+	a.Read = readInt == 1
+	a.Starred = starredInt == 1
+	a.ReadingMinutes = int(minutes.Int64)
+	a.Tags = []string{}
+	if tags.Valid && tags.String != "" {
+		a.Tags = strings.Split(tags.String, ",")
+		sort.Strings(a.Tags)
+	}
+	if readAt.Valid {
+		a.ReadAt = &readAt.Time
+	}
+	if snoozedUntil.Valid {
+		a.SnoozedUntil = &snoozedUntil.Time
+	}
+	return a, nil
+}
+
+// scanArticles reads all rows selected with articleColumns into articles
+func scanArticles(rows *sql.Rows) ([]Article, error) {
+	var articles []Article
+	for rows.Next() {
+		a, err := scanArticle(rows)
+		if err != nil {
+			return nil, err
+		}
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+// ForEachArticle calls fn for every article, read and unread, oldest first,
+// without loading the whole table into memory
+func (s *sqlStore) ForEachArticle(ctx context.Context, fn func(Article) error) error {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+articleColumns+` FROM articles ORDER BY id`))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanArticle(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// refreshUnreadGauge updates the unread articles metric from the database
+func refreshUnreadGauge(ctx context.Context) {
+	count, err := store.UnreadCount(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error refreshing unread gauge", "error", err)
+		return
+	}
+	unreadArticlesGauge.Set(float64(count))
+}
+
+// ArticleFilter selects which articles a listing returns and in what order
+type ArticleFilter struct {
+	// Read is "false" (new articles only, the default), "true" (read only),
+	// "later" (saved for later), "snoozed" or "all". Only "snoozed" includes
+	// articles that are snoozed until a future time.
+	Read string
+	// Sort is a key of articleSortOrders, defaulting to defaultSort
+	Sort string
+	// From and To bound created_at, From inclusive and To exclusive. Zero means unbounded.
+	From time.Time
+	To   time.Time
+	// Hosts limits results to articles from these hosts, ExcludeHosts hides them
+	Hosts        []string
+	ExcludeHosts []string
+}
+
+// Date-only layout accepted by the from and to params
+const dateParamLayout = "2006-01-02"
+
+// parseDateParam parses a from/to value as RFC3339 or YYYY-MM-DD (UTC). When
+// endOfRange is set the result is the exclusive end of the given moment, so a
+// bare date covers the whole day.
+func parseDateParam(value string, endOfRange bool) (time.Time, error) {
+	if t, err := time.Parse(dateParamLayout, value); err == nil {
+		if endOfRange {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, expected RFC3339 or YYYY-MM-DD", value)
+	}
+	if endOfRange {
+		// Stored timestamps have second precision
+		t = t.Truncate(time.Second).Add(time.Second)
+	}
+	return t, nil
+}
+
+// parseDateRange reads the from and to query params into the filter
+func parseDateRange(r *http.Request, filter *ArticleFilter) error {
+	if from := r.URL.Query().Get("from"); from != "" {
+		t, err := parseDateParam(from, false)
+		if err != nil {
+			return err
+		}
+		filter.From = t
+	}
+	if to := r.URL.Query().Get("to"); to != "" {
+		t, err := parseDateParam(to, true)
+		if err != nil {
+			return err
+		}
+		filter.To = t
+	}
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return fmt.Errorf("from must be before to")
+	}
+	return nil
+}
+
+// placeholders returns n comma-separated ? placeholders for an IN list
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// parseHostFilter reads the host and exclude_host params into the filter. Each
+// may be repeated or hold a comma-separated list.
+func parseHostFilter(r *http.Request, filter *ArticleFilter) {
+	filter.Hosts = hostParam(r.URL.Query()["host"])
+	filter.ExcludeHosts = hostParam(r.URL.Query()["exclude_host"])
+}
+
+// hostParam splits and normalizes the values of a host param
+func hostParam(values []string) []string {
+	var hosts []string
+	for _, value := range values {
+		for _, host := range strings.Split(value, ",") {
+			if host = normalizeHost(host); host != "" {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// Sort orders for article listings. User input only ever selects one of these
+// fixed clauses, it's never put into the SQL itself.
+var articleSortOrders = map[string]string{
+	"newest": "created_at DESC, id DESC",
+	"oldest": "created_at ASC, id ASC",
+	"points": "points DESC, created_at DESC, id DESC",
+}
+
+// Sort order used when none is given
+const defaultSort = "newest"
+
+// orderBy returns the SQL ORDER BY clause for the filter's sort
+func (f ArticleFilter) orderBy() (string, error) {
+	sort := f.Sort
+	if sort == "" {
+		sort = defaultSort
+	}
+	order, ok := articleSortOrders[sort]
+	if !ok {
+		return "", fmt.Errorf("invalid sort %q", f.Sort)
+	}
+	return order, nil
+}
+
+// readFilterForShow maps the home page's show param (unread, later, read, all) to a read filter
+func readFilterForShow(show string) (string, error) {
+	switch show {
+	case "", "unread":
+		return "false", nil
+	case "later":
+		return "later", nil
+	case "read":
+		return "true", nil
+	case "all":
+		return "all", nil
+	default:
+		return "", fmt.Errorf("invalid show value %q", show)
+	}
+}
+
+// where builds the SQL WHERE clause and arguments for the filter
+func (f ArticleFilter) where() (string, []any, error) {
+	var where string
+	var args []any
+	switch f.Read {
+	case "", "false":
+		where, args = "status = ?", []any{articleStatusNew}
+	case "true":
+		where, args = "read = ?", []any{1}
+	case "later":
+		where, args = "status = ?", []any{articleStatusLater}
+	case "snoozed":
+		where, args = "snoozed_until > ?", []any{snoozeNow()}
+	case "all":
+		where = "1 = 1"
+	default:
+		return "", nil, fmt.Errorf("invalid read filter %q", f.Read)
+	}
+	if f.Read != "snoozed" {
+		where += " AND " + notSnoozed
+		args = append(args, snoozeNow())
+	}
+
+	if !f.From.IsZero() {
+		where += " AND created_at >= ?"
+		args = append(args, f.From.UTC().Format(sqliteTimeFormat))
+	}
+	if !f.To.IsZero() {
+		where += " AND created_at < ?"
+		args = append(args, f.To.UTC().Format(sqliteTimeFormat))
+	}
+	if len(f.Hosts) > 0 {
+		where += " AND host IN (" + placeholders(len(f.Hosts)) + ")"
+		for _, host := range f.Hosts {
+			args = append(args, host)
+		}
+	}
+	if len(f.ExcludeHosts) > 0 {
+		where += " AND host NOT IN (" + placeholders(len(f.ExcludeHosts)) + ")"
+		for _, host := range f.ExcludeHosts {
+			args = append(args, host)
+		}
+	}
+	return where, args, nil
+}
+
+// ListArticles retrieves a page of articles matching the filter. A negative limit
+// returns every matching article.
+func (s *sqlStore) ListArticles(ctx context.Context, filter ArticleFilter, limit, offset int) ([]Article, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	orderBy, err := filter.orderBy()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + articleColumns + `
+		FROM articles
+		WHERE ` + where + `
+		ORDER BY ` + orderBy
+	if limit >= 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, limit, offset)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanArticles(rows)
+}
+
+// getArticles retrieves every article matching the filter
+func getArticles(ctx context.Context, filter ArticleFilter) ([]Article, error) {
+	return store.ListArticles(ctx, filter, -1, 0)
+}
+
+// CountArticles returns the number of articles matching the filter
+func (s *sqlStore) CountArticles(ctx context.Context, filter ArticleFilter) (int, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	err = s.db.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM articles WHERE `+where), args...).Scan(&count)
+	return count, err
+}
+
+// getArticlesPage retrieves a page of articles along with the total number matching the filter
+func getArticlesPage(ctx context.Context, filter ArticleFilter, limit, offset int) ([]Article, int, error) {
+	total, err := store.CountArticles(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	articles, err := store.ListArticles(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return articles, total, nil
+}
+
+// parsePagination reads the page and per_page query params. Missing values get
+// the defaults and out of range ones are clamped: page to 1..maxPage and
+// per_page to maxPerPage, with 0 or less meaning the default. Values that
+// aren't numbers are an error.
+func parsePagination(r *http.Request) (page, perPage int, err error) {
+	page, perPage = 1, defaultPerPage
+	if v := r.URL.Query().Get("page"); v != "" {
+		page, err = strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid page %q, expected a number", v)
+		}
+	}
+	if v := r.URL.Query().Get("per_page"); v != "" {
+		perPage, err = strconv.Atoi(v)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid per_page %q, expected a number", v)
+		}
+	}
+
+	page = min(max(page, 1), maxPage)
+	if perPage < 1 {
+		perPage = defaultPerPage
+	}
+	perPage = min(perPage, maxPerPage)
+	return page, perPage, nil
+}
+
+// buildFTSQuery turns free-form user input into a safe FTS5 query by quoting
+// each term, so punctuation and FTS operators are matched literally
+func buildFTSQuery(input string) string {
+	var terms []string
+	for _, term := range strings.Fields(input) {
+		term = strings.ReplaceAll(term, `"`, "")
+		if term == "" {
+			continue
+		}
+		terms = append(terms, `"`+term+`"`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// Search returns articles whose titles match the query, best matches first
+func (s *sqliteStore) Search(ctx context.Context, query string) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT `+articleColumns+`
+		FROM articles
+		JOIN (SELECT rowid, rank FROM articles_fts WHERE articles_fts MATCH ?) AS matches
+			ON matches.rowid = articles.id
+		ORDER BY matches.rank
+		LIMIT ?
+	`), query, maxSearchResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanArticles(rows)
+}
+
+// GetArticle retrieves a single article, returning sql.ErrNoRows if it doesn't exist
+func (s *sqlStore) GetArticle(ctx context.Context, id int) (Article, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`SELECT `+articleColumns+` FROM articles WHERE id = ?`), id)
+	if err != nil {
+		return Article{}, err
+	}
+	defer rows.Close()
+
+	articles, err := scanArticles(rows)
+	if err != nil {
+		return Article{}, err
+	}
+	if len(articles) == 0 {
+		return Article{}, sql.ErrNoRows
+	}
+	return articles[0], nil
+}
+
+// MarkRead marks an article as read or unread, returning the number of rows updated
+func (s *sqlStore) MarkRead(ctx context.Context, id int, read bool) (int64, error) {
+	readInt := 0
+	if read {
+		readInt = 1
+	}
+	// Keep the original read_at if an already-read article is marked read again
+	result, err := s.db.ExecContext(ctx, s.rebind(`
+		UPDATE articles
+		SET read = ?, read_at = CASE WHEN ? = 1 THEN COALESCE(read_at, CURRENT_TIMESTAMP) ELSE NULL END,
+			status = CASE WHEN ? = 1 THEN 'read' ELSE 'new' END
+		WHERE id = ?
+	`), readInt, readInt, readInt, id)
+	if err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return result.RowsAffected()
+}
+
+// MarkLater moves an article to the read later queue, taking it off the
+// main list without marking it read
+func (s *sqlStore) MarkLater(ctx context.Context, id int) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.rebind(`
+		UPDATE articles SET status = 'later', read = 0, read_at = NULL WHERE id = ?
+	`), id)
+	if err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return result.RowsAffected()
+}
+
+// Snooze hides an article from listings until the given time. A zero
+// time ends the snooze. It returns the number of rows updated.
+func (s *sqlStore) Snooze(ctx context.Context, id int, until time.Time) (int64, error) {
+	var value any
+	if !until.IsZero() {
+		value = until.UTC().Format(sqliteTimeFormat)
+	}
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE articles SET snoozed_until = ? WHERE id = ?`), value, id)
+	if err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return result.RowsAffected()
+}
+
+// WakeSnoozed clears snoozes that have run out. The listings already
+// show those articles again, this drops cached pages that still leave them out.
+func (s *sqlStore) WakeSnoozed(ctx context.Context) {
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE articles SET snoozed_until = NULL WHERE snoozed_until <= ?`), snoozeNow())
+	if err != nil {
+		slog.ErrorContext(ctx, "Error waking snoozed articles", "error", err)
+		return
+	}
+	if woken, _ := result.RowsAffected(); woken > 0 {
+		invalidateHomeCache()
+		slog.InfoContext(ctx, "Snoozed articles are back", "count", woken)
+	}
+}
+
+// DeleteArticle removes an article outright, along with its tags. It returns
+// the number of rows deleted, so 0 means there was no such article.
+func (s *sqlStore) DeleteArticle(ctx context.Context, id int) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.rebind(`DELETE FROM articles WHERE id = ?`), id)
+	if err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return result.RowsAffected()
+}
+
+// HostCount is one entry of /api/hosts
+type HostCount struct {
+	Host  string `json:"host"`
+	Count int    `json:"count"`
+}
+
+// HostCounts returns each host with the number of articles matching the
+// filter, most common first
+func (s *sqlStore) HostCounts(ctx context.Context, filter ArticleFilter) ([]HostCount, error) {
+	where, args, err := filter.where()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT host, COUNT(*) FROM articles
+		WHERE `+where+` AND host != ''
+		GROUP BY host
+		ORDER BY COUNT(*) DESC, host
+	`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []HostCount
+	for rows.Next() {
+		var c HostCount
+		if err := rows.Scan(&c.Host, &c.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// LaterArticles returns the read later queue, oldest first so it's worked through in order.
+// Snoozed articles are left out.
+func (s *sqlStore) LaterArticles(ctx context.Context) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT `+articleColumns+`
+		FROM articles
+		WHERE status = 'later' AND `+notSnoozed+`
+		ORDER BY created_at ASC, id ASC
+	`), snoozeNow())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanArticles(rows)
+}
+
+// ToggleRead flips an article's read state in a single statement so two
+// concurrent toggles can't both see the same starting state
+func (s *sqlStore) ToggleRead(ctx context.Context, id int) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.rebind(`
+		UPDATE articles
+		SET read = 1 - read, read_at = CASE WHEN read = 1 THEN NULL ELSE CURRENT_TIMESTAMP END,
+			status = CASE WHEN read = 1 THEN 'new' ELSE 'read' END
+		WHERE id = ?
+	`), id)
+	if err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return result.RowsAffected()
+}
+
+// MarkManyRead marks several articles read or unread in one transaction,
+// returning how many rows were updated
+func (s *sqlStore) MarkManyRead(ctx context.Context, ids []int, read bool) (int64, error) {
+	readInt := 0
+	if read {
+		readInt = 1
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Same update as MarkRead
+	stmt, err := tx.PrepareContext(ctx, s.rebind(`
+		UPDATE articles
+		SET read = ?, read_at = CASE WHEN ? = 1 THEN COALESCE(read_at, CURRENT_TIMESTAMP) ELSE NULL END,
+			status = CASE WHEN ? = 1 THEN 'read' ELSE 'new' END
+		WHERE id = ?
+	`))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var updated int64
+	for _, id := range ids {
+		result, err := stmt.ExecContext(ctx, readInt, readInt, readInt, id)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		updated += n
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return updated, nil
+}
+
+// MarkStarred stars or unstars an article, returning the number of rows updated
+func (s *sqlStore) MarkStarred(ctx context.Context, id int, starred bool) (int64, error) {
+	starredInt := 0
+	if starred {
+		starredInt = 1
+	}
+	result, err := s.db.ExecContext(ctx, s.rebind(`UPDATE articles SET starred = ? WHERE id = ?`), starredInt, id)
+	if err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return result.RowsAffected()
+}
+
+// StarredArticles returns all starred articles, newest first
+func (s *sqlStore) StarredArticles(ctx context.Context) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT `+articleColumns+`
+		FROM articles
+		WHERE starred = 1
+		ORDER BY created_at DESC, id DESC
+	`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanArticles(rows)
+}
+
+// Readable content fetching for offline reading. Articles are fetched one at a
+// time with a delay in between so a big sync doesn't hammer anyone's site.
+const (
+	contentFetchDelay     = 2 * time.Second
+	contentFetchBatchSize = 50
+	maxContentBodyBytes   = 5 << 20
+)
+
+// Reading speed used for reading time estimates
+const readingWordsPerMinute = 200
+
+// readingMinutes estimates how long content takes to read, rounded up to a
+// whole minute. Content with no words takes 0.
+func readingMinutes(content string) int {
+	words := len(strings.Fields(content))
+	return (words + readingWordsPerMinute - 1) / readingWordsPerMinute
+}
+
+// Whether readable content is fetched for new articles, set from FETCH_CONTENT
+var contentFetchEnabled bool
+
+// Set while readable content is being fetched so fetches never overlap
+var contentFetchRunning atomic.Bool
+
+// Elements whose text is never part of an article's readable body
+var nonContentElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Nav:      true,
+	atom.Header:   true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Button:   true,
+	atom.Iframe:   true,
+	atom.Svg:      true,
+}
+
+// Block elements that each become a paragraph in the reader view
+var paragraphElements = map[atom.Atom]bool{
+	atom.P:          true,
+	atom.H1:         true,
+	atom.H2:         true,
+	atom.H3:         true,
+	atom.H4:         true,
+	atom.H5:         true,
+	atom.H6:         true,
+	atom.Li:         true,
+	atom.Pre:        true,
+	atom.Blockquote: true,
+}
+
+// extractReadableText returns the main text of a page as paragraphs separated by
+// blank lines. It reads from the page's <article> or <main> element if it has one,
+// and skips navigation, scripts and other page furniture.
+func extractReadableText(doc *html.Node) string {
+	root := findFirst(doc, func(n *html.Node) bool { return n.DataAtom == atom.Article })
+	if root == nil {
+		root = findFirst(doc, func(n *html.Node) bool { return n.DataAtom == atom.Main })
+	}
+	if root == nil {
+		root = doc
+	}
+
+	var paragraphs []string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && nonContentElements[n.DataAtom] {
+			return
+		}
+		if n.Type == html.ElementNode && paragraphElements[n.DataAtom] {
+			if text := strings.Join(strings.Fields(readableTextContent(n)), " "); text != "" {
+				paragraphs = append(paragraphs, text)
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(root)
+
+	return strings.Join(paragraphs, "\n\n")
+}
+
+// readableTextContent is textContent that skips non-content elements
+func readableTextContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if n.Type == html.ElementNode && nonContentElements[n.DataAtom] {
+		return ""
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		sb.WriteString(readableTextContent(c))
+	}
+	return sb.String()
+}
+
+// fetchReadableContent fetches a page and extracts its readable text. Pages that
+// aren't HTML return "" without an error.
+func fetchReadableContent(ctx context.Context, link string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("page returned status %d", resp.StatusCode)
+	}
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	if !strings.Contains(contentType, "text/html") && !strings.Contains(contentType, "application/xhtml+xml") {
+		return "", nil
+	}
+
+	doc, err := html.Parse(io.LimitReader(resp.Body, maxContentBodyBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse page: %w", err)
+	}
+	return extractReadableText(doc), nil
+}
+
+// ArticlesMissingContent returns the newest articles whose content hasn't been fetched
+func (s *sqlStore) ArticlesMissingContent(ctx context.Context, limit int) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT `+articleColumns+`
+		FROM articles
+		WHERE content IS NULL
+		ORDER BY id DESC
+		LIMIT ?
+	`), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanArticles(rows)
+}
+
+// SaveContent stores an article's readable content, "" if none was found,
+// along with its reading time
+func (s *sqlStore) SaveContent(ctx context.Context, id int, content string) error {
+	var minutes sql.NullInt64
+	if content != "" {
+		minutes = sql.NullInt64{Int64: int64(readingMinutes(content)), Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(`UPDATE articles SET content = ?, reading_minutes = ? WHERE id = ?`), content, minutes, id)
+	return err
+}
+
+// GetContent returns an article's readable content, or "" if there is none
+func (s *sqlStore) GetContent(ctx context.Context, id int) (string, error) {
+	var content string
+	err := s.db.QueryRowContext(ctx, s.rebind(`SELECT COALESCE(content, '') FROM articles WHERE id = ?`), id).Scan(&content)
+	return content, err
+}
+
+// fetchPendingContent fetches readable content for new articles that don't have it yet
+func fetchPendingContent(ctx context.Context) {
+	if !contentFetchRunning.CompareAndSwap(false, true) {
+		return
+	}
+	defer contentFetchRunning.Store(false)
+
+	articles, err := store.ArticlesMissingContent(ctx, contentFetchBatchSize)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading articles to fetch content for", "error", err)
+		return
+	}
+
+	withContent := 0
+	for i, a := range articles {
+		if i > 0 {
+			select {
+			case <-time.After(contentFetchDelay):
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		content, err := fetchReadableContent(ctx, a.ArticleLink)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// Saving an empty body means a broken link isn't retried forever
+			slog.WarnContext(ctx, "Failed to fetch article content", "error", err, "id", a.ID, "url", a.ArticleLink)
+		}
+		if err := store.SaveContent(ctx, a.ID, content); err != nil {
+			slog.ErrorContext(ctx, "Error saving article content", "error", err, "id", a.ID)
+			return
+		}
+		if content != "" {
+			withContent++
+		}
+	}
+
+	if len(articles) > 0 {
+		slog.InfoContext(ctx, "Fetched article content", "articles", len(articles), "with_content", withContent)
+	}
+}
+
+// Email digests of new articles, sent after syncs when an SMTP host is configured
+const (
+	digestScheduleSync  = "sync"
+	digestScheduleDaily = "daily"
+)
+
+// Minimum time between digests on the daily schedule
+const digestInterval = 24 * time.Hour
+
+// Whether digests are sent, set when SMTP_HOST is configured
+var digestEnabled bool
+
+// SMTP settings for digests, set from the SMTP_* config
+var smtpSettings struct {
+	host, port, user, pass, from string
+	to                           []string
+	schedule                     string
+}
+
+// Guards the digest state below, held while a digest is being sent
+var digestMu sync.Mutex
+
+// Articles waiting for the next daily digest and when the last digest went out
+var (
+	pendingDigest  []Article
+	lastDigestSent time.Time
+)
+
+// parseAddressList splits a comma-separated list of email addresses
+func parseAddressList(raw string) []string {
+	var addrs []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			addrs = append(addrs, part)
+		}
+	}
+	return addrs
+}
+
+// loadLastDigestSent restores the persisted time of the last digest into memory
+func loadLastDigestSent(ctx context.Context) error {
+	value, err := store.GetMeta(ctx, metaKeyLastDigestSent)
+	if err != nil || value == "" {
+		return err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return fmt.Errorf("failed to parse stored last digest time %q: %w", value, err)
+	}
+
+	digestMu.Lock()
+	lastDigestSent = t
+	digestMu.Unlock()
+	return nil
+}
+
+// sendDigest emails the articles from a sync. On the daily schedule articles are
+// held until a day has passed since the last digest. Failures are logged and the
+// articles are kept for the next attempt.
+func sendDigest(ctx context.Context, articles []Article) {
+	digestMu.Lock()
+	defer digestMu.Unlock()
+
+	pendingDigest = append(pendingDigest, articles...)
+	if len(pendingDigest) == 0 {
+		return
+	}
+	if smtpSettings.schedule == digestScheduleDaily && time.Since(lastDigestSent) < digestInterval {
+		slog.DebugContext(ctx, "Holding articles for daily digest", "articles", len(pendingDigest))
+		return
+	}
+
+	msg, err := buildDigestEmail(pendingDigest)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error building email digest", "error", err)
+		return
+	}
+
+	var auth smtp.Auth
+	if smtpSettings.user != "" {
+		auth = smtp.PlainAuth("", smtpSettings.user, smtpSettings.pass, smtpSettings.host)
+	}
+	addr := net.JoinHostPort(smtpSettings.host, smtpSettings.port)
+	if err := smtp.SendMail(addr, auth, smtpSettings.from, smtpSettings.to, msg); err != nil {
+		slog.ErrorContext(ctx, "Error sending email digest", "error", err, "articles", len(pendingDigest))
+		return
+	}
+
+	slog.InfoContext(ctx, "Sent email digest", "articles", len(pendingDigest), "to", smtpSettings.to)
+	pendingDigest = nil
+	lastDigestSent = time.Now()
+	if err := store.SetMeta(ctx, metaKeyLastDigestSent, lastDigestSent.Format(time.RFC3339Nano)); err != nil {
+		slog.ErrorContext(ctx, "Error saving last digest time", "error", err)
+	}
+}
+
+// buildDigestEmail renders the digest template into a complete HTML email message
+func buildDigestEmail(articles []Article) ([]byte, error) {
+	var body bytes.Buffer
+	if err := currentTemplates().ExecuteTemplate(&body, "digest.html", articles); err != nil {
+		return nil, err
+	}
+
+	subject := fmt.Sprintf("%s: %d new articles", siteTitle, len(articles))
+	if len(articles) == 1 {
+		subject = siteTitle + ": 1 new article"
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", smtpSettings.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(smtpSettings.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// Webhook notifications of new articles, sent after syncs when WEBHOOK_URL is set
+var webhookURL string
+
+// Client for webhook deliveries, with a shorter timeout than feed fetches
+var webhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// Retry policy for webhook deliveries
+const (
+	webhookAttempts  = 3
+	webhookBaseDelay = 2 * time.Second
+)
+
+// WebhookPayload is the JSON body posted to WEBHOOK_URL
+type WebhookPayload struct {
+	Count    int       `json:"count"`
+	Articles []Article `json:"articles"`
+}
+
+// sendWebhook posts the new articles from a sync to the webhook, retrying network
+// errors and 5xx responses. Failures are logged, never returned, so a broken
+// webhook can't affect syncing.
+func sendWebhook(ctx context.Context, articles []Article) {
+	body, err := json.Marshal(WebhookPayload{Count: len(articles), Articles: articles})
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding webhook payload", "error", err)
+		return
+	}
+
+	delay := webhookBaseDelay
+	var lastErr error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			slog.WarnContext(ctx, "Retrying webhook", "attempt", attempt, "delay", delay, "error", lastErr)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			delay *= 2
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewReader(body))
+		if err != nil {
+			slog.ErrorContext(ctx, "Error creating webhook request", "error", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+
+		resp, err := webhookClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			lastErr = fmt.Errorf("webhook returned status %d", resp.StatusCode)
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			slog.ErrorContext(ctx, "Webhook rejected notification", "status", resp.StatusCode)
+			return
+		}
+
+		slog.InfoContext(ctx, "Sent webhook", "articles", len(articles), "status", resp.StatusCode)
+		return
+	}
+
+	slog.ErrorContext(ctx, "Error sending webhook", "attempts", webhookAttempts, "error", lastErr)
+}
+
+// Maximum length of a tag name
+const maxTagLength = 50
+
+// normalizeTag lowercases and trims a tag name and checks it's usable
+func normalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", fmt.Errorf("tag must not be empty")
+	}
+	if len(name) > maxTagLength {
+		return "", fmt.Errorf("tag must be at most %d characters", maxTagLength)
+	}
+	// Commas separate tags in query results and slashes would break /tags/{tag} URLs
+	if strings.ContainsAny(name, ",/") {
+		return "", fmt.Errorf("tag must not contain commas or slashes")
+	}
+	return name, nil
+}
+
+// AddTag attaches a tag to an article, creating the tag if needed.
+// Adding a tag the article already has is a no-op.
+func (s *sqlStore) AddTag(ctx context.Context, articleID int, tag string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.rebind(`INSERT INTO tags (name) VALUES (?) ON CONFLICT DO NOTHING`), tag); err != nil {
+		return fmt.Errorf("failed to create tag: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, s.rebind(`
+		INSERT INTO article_tags (article_id, tag_id)
+		SELECT ?, id FROM tags WHERE name = ?
+		ON CONFLICT DO NOTHING
+	`), articleID, tag)
+	if err != nil {
+		return fmt.Errorf("failed to tag article: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// RemoveTag detaches a tag from an article, returning the number of rows removed
+func (s *sqlStore) RemoveTag(ctx context.Context, articleID int, tag string) (int64, error) {
+	result, err := s.db.ExecContext(ctx, s.rebind(`
+		DELETE FROM article_tags
+		WHERE article_id = ? AND tag_id = (SELECT id FROM tags WHERE name = ?)
+	`), articleID, tag)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ArticlesByTag returns all articles with the given tag, newest first
+func (s *sqlStore) ArticlesByTag(ctx context.Context, tag string) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT `+articleColumns+`
+		FROM articles
+		WHERE id IN (
+			SELECT article_tags.article_id FROM article_tags
+			JOIN tags ON tags.id = article_tags.tag_id
+			WHERE tags.name = ?
+		)
+		ORDER BY created_at DESC, id DESC
+	`), tag)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanArticles(rows)
+}
+
+// RecentlyRead returns articles marked read since the cutoff, most recently read first
+func (s *sqlStore) RecentlyRead(ctx context.Context, since time.Time) ([]Article, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(`
+		SELECT `+articleColumns+`
+		FROM articles
+		WHERE read = 1 AND read_at >= ?
+		ORDER BY read_at DESC, id DESC
+	`), since.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanArticles(rows)
+}
+
+// MarkAllRead marks every new article as read, optionally only those created before a cutoff.
+// Articles saved for later or snoozed are left alone. It returns the number of articles updated.
+func (s *sqlStore) MarkAllRead(ctx context.Context, before time.Time) (int64, error) {
+	const update = `UPDATE articles SET read = 1, read_at = CURRENT_TIMESTAMP, status = 'read' WHERE status = 'new' AND ` + notSnoozed
+	var result sql.Result
+	var err error
+	if before.IsZero() {
+		result, err = s.db.ExecContext(ctx, s.rebind(update), snoozeNow())
+	} else {
+		result, err = s.db.ExecContext(ctx, s.rebind(update+` AND created_at < ?`), snoozeNow(), before.UTC().Format(sqliteTimeFormat))
+	}
+	if err != nil {
+		return 0, err
+	}
+	invalidateHomeCache()
+	return result.RowsAffected()
+}
+
+func addArticleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var req struct {
+		Link string `json:"link"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+
+	id := extractHNID(req.Link)
+	if id == "" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid HN link. Please provide a link like https://news.ycombinator.com/item?id=12345")
+		return
+	}
+
+	article, err := fetchHNItem(r.Context(), id)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching HN item", "error", err, "id", id)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch HN item: "+err.Error())
+		return
+	}
+
+	inserted, err := saveArticle(r.Context(), article)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error saving article", "error", err, "title", article.Title)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to save article")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if inserted {
+		if contentFetchEnabled {
+			runInBackground(func() { fetchPendingContent(backgroundCtx) })
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"status": "success", "message": "Article added"}`)
+	} else {
+		// Article exists, mark it as unread and update timestamp so it shows up at the top
+		err := store.MarkUnreadByLinks(r.Context(), article)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error updating existing article", "error", err, "link", article.ArticleLink)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update existing article")
+			return
+		}
+		fmt.Fprintf(w, `{"status": "success", "message": "Article brought back to top"}`)
+	}
+}
+
+func (s *sqlStore) MarkUnreadByLinks(ctx context.Context, article Article) error {
+	// Match the same way SaveArticles decided the article was a duplicate
+	match, args := `article_link = ? AND comment_link = ?`, []any{article.ArticleLink, article.CommentLink}
+	if dedupBy == dedupByLink {
+		match, args = `article_link = ?`, []any{article.ArticleLink}
+	}
+
+	_, err := s.db.ExecContext(ctx, s.rebind(`
+		UPDATE articles 
+		SET read = 0, read_at = NULL, status = 'new', date = ?, created_at = CURRENT_TIMESTAMP 
+		WHERE `+match), append([]any{article.Date}, args...)...)
+	if err != nil {
+		return err
+	}
+	invalidateHomeCache()
+	return nil
+}
+
+func extractHNID(link string) string {
+	if strings.Contains(link, "id=") {
+		parts := strings.Split(link, "id=")
+		if len(parts) > 1 {
+			id := parts[1]
+			if end := strings.IndexAny(id, "&/ "); end != -1 {
+				id = id[:end]
+			}
+			return id
+		}
+	}
+	return ""
+}
+
+func fetchHNItem(ctx context.Context, id string) (Article, error) {
+	url := fmt.Sprintf("https://hn.algolia.com/api/v1/items/%s", id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Article{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Article{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Article{}, fmt.Errorf("HN API returned status %d", resp.StatusCode)
+	}
+
+	var item struct {
+		Title string `json:"title"`
+		URL   string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+		return Article{}, err
+	}
+
+	if cleanTitle(item.Title) == "" {
+		return Article{}, fmt.Errorf("could not find title for item %s", id)
+	}
+
+	articleLink := item.URL
+	commentLink := fmt.Sprintf("https://news.ycombinator.com/item?id=%s", id)
+	if articleLink == "" {
+		articleLink = commentLink
+	}
+
+	now := time.Now()
+	return Article{
+		Title:       cleanTitle(item.Title),
+		ArticleLink: articleLink,
+		CommentLink: commentLink,
+		Date:        now.Format(time.RFC1123Z),
+		Source:      manualSource,
+		CreatedAt:   now,
+	}, nil
+}
+
+// getCachedHomePage returns the cached render for key, along with the cache
+// generation to pass to setCachedHomePage after rendering on a miss
+func getCachedHomePage(key string) (cachedPage, bool, uint64) {
+	homeCacheMu.RLock()
+	defer homeCacheMu.RUnlock()
+	page, ok := homeCache[key]
+	return page, ok, homeCacheGeneration
+}
+
+// setCachedHomePage stores a render, unless the cache was invalidated since
+// generation was read
+func setCachedHomePage(key string, page cachedPage, generation uint64) {
+	homeCacheMu.Lock()
+	defer homeCacheMu.Unlock()
+	if generation != homeCacheGeneration {
+		return
+	}
+	if len(homeCache) >= maxHomeCacheEntries {
+		clear(homeCache)
+	}
+	homeCache[key] = page
+}
+
+// invalidateHomeCache drops every cached home page. Call it after anything
+// that changes what the home page shows.
+func invalidateHomeCache() {
+	homeCacheMu.Lock()
+	defer homeCacheMu.Unlock()
+	clear(homeCache)
+	homeCacheGeneration++
+}
+
+// staticCacheMiddleware lets browsers keep static files for maxAge before
+// checking for changes again. Zero makes them check on every use.
+func staticCacheMiddleware(maxAge time.Duration, next http.Handler) http.Handler {
+	cacheControl := "no-cache"
+	if maxAge > 0 {
+		cacheControl = fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", cacheControl)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newETag returns a strong ETag for a response body
+func newETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header matches etag
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeHTMLPage writes a rendered page, answering 304 if the client already has it
+func writeHTMLPage(w http.ResponseWriter, r *http.Request, page cachedPage) {
+	w.Header().Set("ETag", page.etag)
+	// Browsers should always revalidate, since the page changes whenever articles do
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), page.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page.body)
+}
+
+// ArticlePage is the JSON form of the home page
+type ArticlePage struct {
+	Articles    []Article `json:"articles"`
+	Total       int       `json:"total"`
+	UnreadCount int       `json:"unread_count"`
+	Show        string    `json:"show"`
+	Sort        string    `json:"sort"`
+	Page        int       `json:"page"`
+	PerPage     int       `json:"per_page"`
+	TotalPages  int       `json:"total_pages"`
+}
+
+// wantsJSON reports whether the home page should be sent as JSON, either because
+// of ?format=json or an Accept header asking for JSON rather than HTML
+func wantsJSON(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// Handler functions
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Only the HTML page is cached, so vary on Accept to keep caches from mixing them up
+	w.Header().Add("Vary", "Accept")
+	asJSON := wantsJSON(r)
+
+	cacheKey := r.URL.RawQuery
+	var generation uint64
+	if homeCacheEnabled && !asJSON {
+		var page cachedPage
+		var ok bool
+		page, ok, generation = getCachedHomePage(cacheKey)
+		if ok {
+			writeHTMLPage(w, r, page)
+			return
+		}
+	}
+
+	show := r.URL.Query().Get("show")
+	readFilter, err := readFilterForShow(show)
+	if err != nil {
+		show, readFilter = "unread", "false"
+	}
+	if show == "" {
+		show = "unread"
+	}
+	sort := r.URL.Query().Get("sort")
+	if _, ok := articleSortOrders[sort]; !ok {
+		sort = defaultSort
+	}
+	filter := ArticleFilter{Read: readFilter, Sort: sort}
+	if err := parseDateRange(r, &filter); err != nil {
+		if asJSON {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	parseHostFilter(r, &filter)
+
+	page, perPage, err := parsePagination(r)
+	if err != nil {
+		if asJSON {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+
+	// Pages rendered around a database error aren't cached
+	cacheable := true
+
+	articles, total, err := getArticlesPage(r.Context(), filter, perPage, (page-1)*perPage)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching articles", "error", err)
+		articles = []Article{}
+		cacheable = false
+	}
+
+	totalPages := (total + perPage - 1) / perPage
+	if totalPages < 1 {
+		totalPages = 1
+	}
+	if page > totalPages {
+		// Clamp to the last page so huge page numbers don't render an empty list
+		page = totalPages
+		articles, err = store.ListArticles(r.Context(), filter, perPage, (page-1)*perPage)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error fetching articles", "error", err)
+			articles = []Article{}
+			cacheable = false
+		}
+	}
+
+	unread, err := store.UnreadCount(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error counting unread articles", "error", err)
+		cacheable = false
+	}
+
+	syncTimeMu.RLock()
+	syncTime := lastSyncTime
+	syncTimeMu.RUnlock()
+
+	data := TemplateData{
+		Title:        siteTitle,
+		Subtitle:     siteSubtitle,
+		LastSyncTime: syncTime,
+		Articles:     articles,
+		UnreadCount:  unread,
+		Show:         show,
+		Sort:         sort,
+		From:         r.URL.Query().Get("from"),
+		To:           r.URL.Query().Get("to"),
+		Host:         strings.Join(filter.Hosts, ","),
+		ExcludeHost:  strings.Join(filter.ExcludeHosts, ","),
+		Page:         page,
+		PerPage:      perPage,
+		TotalPages:   totalPages,
+	}
+
+	if asJSON {
+		// A partial page would look like real data, so JSON clients get the error
+		if !cacheable {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch articles")
+			return
+		}
+		if articles == nil {
+			articles = []Article{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ArticlePage{
+			Articles:    articles,
+			Total:       total,
+			UnreadCount: unread,
+			Show:        show,
+			Sort:        sort,
+			Page:        page,
+			PerPage:     perPage,
+			TotalPages:  totalPages,
+		})
+		return
+	}
+
+	body, err := renderTemplate("home.html", data)
+	if err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Template error", "error", err)
+		return
+	}
+
+	rendered := cachedPage{body: body, etag: newETag(body)}
+	if homeCacheEnabled && cacheable {
+		setCachedHomePage(cacheKey, rendered, generation)
+	}
+	writeHTMLPage(w, r, rendered)
+}
+
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	wait := r.URL.Query().Get("wait") == "true"
+	if wait && r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed, use POST to wait for a sync")
+		return
+	}
+	if !tryStartSync() {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, `{"status": "already running"}`)
+		return
+	}
+	// The token is only taken once a sync is certain to start, so rejected
+	// requests don't hold back the next real one
+	if ok, retryAfter := manualSyncLimiter.allow(time.Now()); !ok {
+		finishSync()
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeJSONError(w, http.StatusTooManyRequests, "Too many syncs, try again in "+retryAfter.Round(time.Second).String())
+		return
+	}
+
+	if wait {
+		defer finishSync()
+		syncAndWait(w, r)
+		return
+	}
+
+	// Run the feed processing asynchronously. It outlives this request, so it
+	// uses the background context that's cancelled on shutdown.
+	runInBackground(func() {
+		defer finishSync()
+		processFeed(backgroundCtx)
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status": "sync started", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
+}
+
+// SyncResult is the response to a sync that was waited on
+type SyncResult struct {
+	Status string `json:"status"`
+	SyncStats
+	Error string `json:"error,omitempty"`
+}
+
+// syncAndWait runs a sync within the request and reports how it went. The caller
+// must hold the sync slot and have checked the request is a POST.
+func syncAndWait(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	// Shutdown cancels background work but not requests, so stop on either
+	stop := context.AfterFunc(backgroundCtx, cancel)
+	defer stop()
+
+	// Syncs can take longer than the server's usual write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(syncTimeout + 5*time.Second)); err != nil {
+		slog.WarnContext(r.Context(), "Failed to extend write deadline for sync", "error", err)
+	}
+
+	stats, err := processFeed(ctx)
+	result := SyncResult{Status: syncOutcome(err), SyncStats: stats}
+	status := http.StatusOK
+	if err != nil {
+		result.Error = err.Error()
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			status = http.StatusGatewayTimeout
+		case ctx.Err() != nil:
+			result.Status = "cancelled"
+			status = http.StatusServiceUnavailable
+		default:
+			status = http.StatusBadGateway
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+// syncDryRunHandler shows what a sync would parse from the feeds right now,
+// without writing to the database
+func syncDryRunHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), syncTimeout)
+	defer cancel()
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(syncTimeout + 5*time.Second)); err != nil {
+		slog.WarnContext(r.Context(), "Failed to extend write deadline for dry run", "error", err)
+	}
+
+	result := dryRunFeeds(ctx)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// reparseHandler re-runs the parsers over saved feed items, for use after a
+// parser fix. It shares the sync slot so it can't race a sync's inserts.
+func reparseHandler(w http.ResponseWriter, r *http.Request) {
+	if !tryStartSync() {
+		writeJSONError(w, http.StatusConflict, "A sync is running, try again when it finishes")
+		return
+	}
+	defer finishSync()
+
+	result, err := store.ReparseArticles(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to reparse articles")
+		slog.ErrorContext(r.Context(), "Error reparsing articles", "error", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "Reparsed saved feed items", "items", result.Items, "articles", result.Articles,
+		"updated", result.Updated, "unmatched", result.Unmatched, "failed", result.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// vacuumHandler handles POST /admin/vacuum, reporting how much space was reclaimed
+func vacuumHandler(w http.ResponseWriter, r *http.Request) {
+	if !tryStartSync() {
+		writeJSONError(w, http.StatusConflict, "A sync is running, try again when it finishes")
+		return
+	}
+	defer finishSync()
+
+	result, err := store.Vacuum(r.Context())
+	if errors.Is(err, errVacuumUnsupported) {
+		writeJSONError(w, http.StatusNotImplemented, "Vacuum is only available with SQLite")
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to vacuum database")
+		slog.ErrorContext(r.Context(), "Error vacuuming database", "error", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// syncStatusHandler reports whether a sync is running and how the last one went
+func syncStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(currentSyncStatus())
+}
+
+// syncHistoryHandler returns recent syncs with their new article counts, newest first
+func syncHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultSyncHistoryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSyncHistoryLimit {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit parameter, expected a number from 1 to %d", maxSyncHistoryLimit))
+			return
+		}
+		limit = n
+	}
+
+	runs, err := store.SyncRuns(r.Context(), limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch sync history")
+		slog.ErrorContext(r.Context(), "Error fetching sync history", "error", err)
+		return
+	}
+	if runs == nil {
+		runs = []SyncRun{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(runs)
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if !store.SearchEnabled() {
+		writeJSONError(w, http.StatusNotImplemented, "Search is not available")
+		return
+	}
+
+	query := buildFTSQuery(r.URL.Query().Get("q"))
+	if query == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing q parameter")
+		return
+	}
+
+	articles, err := store.Search(r.Context(), query)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to search articles")
+		slog.ErrorContext(r.Context(), "Error searching articles", "error", err, "query", query)
+		return
+	}
+	if articles == nil {
+		articles = []Article{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(articles)
+}
+
+// parseAPIFilter reads the read, sort, from and to params shared by the JSON
+// listing endpoints. Errors are suitable for returning to the client.
+func parseAPIFilter(r *http.Request) (ArticleFilter, error) {
+	filter := ArticleFilter{Read: r.URL.Query().Get("read"), Sort: r.URL.Query().Get("sort")}
+	if _, _, err := filter.where(); err != nil {
+		return filter, errors.New("Invalid read parameter, expected true, false, later, snoozed or all")
+	}
+	if _, err := filter.orderBy(); err != nil {
+		return filter, errors.New("Invalid sort parameter, expected newest, oldest or points")
+	}
+	if err := parseDateRange(r, &filter); err != nil {
+		return filter, err
+	}
+	parseHostFilter(r, &filter)
+	return filter, nil
+}
+
+func apiArticlesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	filter, err := parseAPIFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Every matching article is returned unless a page is asked for
+	limit, offset := -1, 0
+	if r.URL.Query().Has("page") || r.URL.Query().Has("per_page") {
+		page, perPage, err := parsePagination(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		limit, offset = perPage, (page-1)*perPage
+	}
+
+	articles, err := store.ListArticles(r.Context(), filter, limit, offset)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch articles")
+		slog.ErrorContext(r.Context(), "Error fetching articles", "error", err)
+		return
+	}
+	if articles == nil {
+		articles = []Article{}
+	}
+
+	// The ETag is a hash of the response itself, so it changes with anything that
+	// would change the listing, including read and starred state, and pollers get
+	// a 304 otherwise
+	body, err := json.Marshal(articles)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to encode articles")
+		slog.ErrorContext(r.Context(), "Error encoding articles", "error", err)
+		return
+	}
+	body = append(body, '\n')
+	etag := newETag(body)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// linksHandler returns just the article URLs matching the listing filters, unread
+// by default, so a bookmarklet can open them all. An optional limit caps how many
+// are returned and format=text gives one URL per line instead of JSON.
+func linksHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAPIFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid limit parameter, expected a positive number")
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "text" {
+		writeJSONError(w, http.StatusBadRequest, "Invalid format parameter, expected json or text")
+		return
+	}
+
+	articles, err := getArticles(r.Context(), filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch articles")
+		slog.ErrorContext(r.Context(), "Error fetching articles", "error", err)
+		return
+	}
+	if limit > 0 && len(articles) > limit {
+		articles = articles[:limit]
+	}
+
+	links := make([]string, 0, len(articles))
+	for _, a := range articles {
+		links = append(links, a.ArticleLink)
+	}
+
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, link := range links {
+			fmt.Fprintln(w, link)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(links)
+}
+
+// hostsHandler lists the hosts of articles matching the listing filters (unread
+// by default) with how many articles each has
+func hostsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAPIFilter(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	counts, err := store.HostCounts(r.Context(), filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to count hosts")
+		slog.ErrorContext(r.Context(), "Error counting hosts", "error", err)
+		return
+	}
+	if counts == nil {
+		counts = []HostCount{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(counts)
+}
+
+func unreadCountHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := store.UnreadCount(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error counting unread articles", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to count unread articles")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"unread": %d}`, count)
+}
+
+// faviconBadge caches the badge for the last unread count it was rendered for
+var faviconBadge struct {
+	mu    sync.Mutex
+	count int
+	svg   []byte
+	etag  string
+}
+
+// renderFaviconBadge draws the site icon as an SVG with the unread count in a
+// circle in the corner. Counts over 99 show as "99+" and zero shows no badge.
+func renderFaviconBadge(count int) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<svg xmlns="http://www.w3.org/2000/svg" width="32" height="32" viewBox="0 0 32 32">`)
+	b.WriteString(`<rect width="32" height="32" rx="4" fill="#ff6600"/>`)
+	b.WriteString(`<text x="11" y="22" font-family="Verdana, Arial, sans-serif" font-size="18" font-weight="bold" fill="#fff" text-anchor="middle">Y</text>`)
+	if count > 0 {
+		label := strconv.Itoa(count)
+		fontSize := 12
+		if count > 99 {
+			label = "99+"
+			fontSize = 8
+		} else if count > 9 {
+			fontSize = 10
+		}
+		b.WriteString(`<circle cx="23" cy="9" r="9" fill="#d50000" stroke="#fff" stroke-width="1.5"/>`)
+		fmt.Fprintf(&b, `<text x="23" y="9" dy="0.35em" font-family="Verdana, Arial, sans-serif" font-size="%d" font-weight="bold" fill="#fff" text-anchor="middle">%s</text>`, fontSize, label)
+	}
+	b.WriteString(`</svg>`)
+	return b.Bytes()
+}
+
+// faviconBadgeHandler serves the favicon with the current unread count. The SVG
+// is only re-rendered when the count changes.
+func faviconBadgeHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := store.UnreadCount(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error counting unread articles", "error", err)
+		http.Error(w, "Failed to count unread articles", http.StatusInternalServerError)
+		return
+	}
+
+	faviconBadge.mu.Lock()
+	if faviconBadge.svg == nil || faviconBadge.count != count {
+		faviconBadge.count = count
+		faviconBadge.svg = renderFaviconBadge(count)
+		faviconBadge.etag = newETag(faviconBadge.svg)
+	}
+	svg, etag := faviconBadge.svg, faviconBadge.etag
+	faviconBadge.mu.Unlock()
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
+
+// ReaderState is the minimal unread state polled by the browser for keyboard navigation
+type ReaderState struct {
+	UnreadIDs   []int  `json:"unread_ids"`
+	UnreadCount int    `json:"unread_count"`
+	Version     string `json:"version"`
+}
+
+// stateHandler returns the unread article IDs along with a version that changes
+// whenever they do. The version doubles as an ETag so unchanged polls get a 304.
+func stateHandler(w http.ResponseWriter, r *http.Request) {
+	ids, err := store.UnreadArticleIDs(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error fetching unread article ids", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch unread articles")
+		return
+	}
+
+	idList, _ := json.Marshal(ids)
+	etag := newETag(idList)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ReaderState{
+		UnreadIDs:   ids,
+		UnreadCount: len(ids),
+		Version:     strings.Trim(etag, `"`),
+	})
+}
+
+func feedHandler(w http.ResponseWriter, r *http.Request) {
+	articles, err := getArticles(r.Context(), ArticleFilter{Read: "false"})
+	if err != nil {
+		http.Error(w, "Failed to fetch articles", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error fetching articles", "error", err)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	feed := RSS{
+		Version: "2.0",
+		Channel: Channel{
+			Title:         siteTitle + " - Unread Articles",
+			Link:          fmt.Sprintf("%s://%s/", scheme, r.Host),
+			Description:   "Unread articles from " + siteTitle,
+			LastBuildDate: time.Now().Format(time.RFC1123Z),
+			Items:         make([]Item, 0, len(articles)),
+		},
+	}
+	for _, a := range articles {
+		feed.Channel.Items = append(feed.Channel.Items, Item{
+			Title:       a.Title,
+			Link:        a.ArticleLink,
+			GUID:        a.CommentLink,
+			PubDate:     a.CreatedAt.Format(time.RFC1123Z),
+			Description: fmt.Sprintf(`<a href="%s">Comments</a>`, html.EscapeString(a.CommentLink)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding feed", "error", err)
+	}
+}
+
+// jsonFeedHandler serves the unread articles as a JSON Feed, the counterpart of /feed.xml
+func jsonFeedHandler(w http.ResponseWriter, r *http.Request) {
+	articles, err := getArticles(r.Context(), ArticleFilter{Read: "false"})
+	if err != nil {
+		http.Error(w, "Failed to fetch articles", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error fetching articles", "error", err)
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	homePage := fmt.Sprintf("%s://%s/", scheme, r.Host)
+
+	feed := JSONFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       siteTitle + " - Unread Articles",
+		HomePageURL: homePage,
+		FeedURL:     homePage + "feed.json",
+		Description: "Unread articles from " + siteTitle,
+		Items:       make([]JSONFeedItem, 0, len(articles)),
+	}
+	for _, a := range articles {
+		// Same id as the RSS guid, falling back to the link for articles without comments
+		id := a.CommentLink
+		if id == "" {
+			id = a.ArticleLink
+		}
+		feed.Items = append(feed.Items, JSONFeedItem{
+			ID:            id,
+			URL:           a.ArticleLink,
+			Title:         a.Title,
+			ContentHTML:   fmt.Sprintf(`<a href="%s">Comments</a>`, html.EscapeString(a.CommentLink)),
+			DatePublished: a.CreatedAt.UTC().Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/feed+json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(feed); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding JSON feed", "error", err)
+	}
+}
+
+func opmlExportHandler(w http.ResponseWriter, r *http.Request) {
+	doc := OPML{
+		Version: "2.0",
+		Head: OPMLHead{
+			Title:       siteTitle + " Feeds",
+			DateCreated: time.Now().Format(time.RFC1123Z),
+		},
+	}
+	for _, feedURL := range feedURLs {
+		title, err := store.GetFeedTitle(r.Context(), feedURL)
+		if err != nil {
+			slog.WarnContext(r.Context(), "Failed to load feed title", "error", err, "feed", feedURL)
+		}
+		if title == "" {
+			title = feedURL
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, OPMLOutline{
+			Type:   "rss",
+			Text:   title,
+			Title:  title,
+			XMLURL: feedURL,
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=feeds.opml")
+	io.WriteString(w, xml.Header)
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		slog.ErrorContext(r.Context(), "Error encoding OPML", "error", err)
+	}
+}
+
+func csvExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=articles.csv")
+
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"id", "date", "title", "article_link", "comment_link", "read", "created_at"})
+
+	err := store.ForEachArticle(r.Context(), func(a Article) error {
+		return writer.Write([]string{
+			strconv.Itoa(a.ID),
+			a.Date,
+			a.Title,
+			a.ArticleLink,
+			a.CommentLink,
+			strconv.FormatBool(a.Read),
+			a.CreatedAt.Format(time.RFC3339),
+		})
+	})
+	writer.Flush()
+
+	// Headers are already sent by now, so all we can do is log and stop
+	if err == nil {
+		err = writer.Error()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error exporting CSV", "error", err)
+	}
+}
+
+func jsonExportHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=articles.json")
+
+	// Stream the array one article at a time rather than building it in memory
+	encoder := json.NewEncoder(w)
+	first := true
+	io.WriteString(w, "[")
+	err := store.ForEachArticle(r.Context(), func(a Article) error {
+		if !first {
+			io.WriteString(w, ",")
+		}
+		first = false
+		return encoder.Encode(a)
+	})
+	io.WriteString(w, "]\n")
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Error exporting JSON", "error", err)
+	}
+}
+
+func jsonImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	var articles []Article
+	if err := json.NewDecoder(r.Body).Decode(&articles); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid request, expected a JSON array of articles")
+		return
+	}
+	for i, a := range articles {
+		if err := validateImportedArticle(a); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Invalid article at index %d: %v", i, err))
+			return
+		}
+	}
+
+	inserted, skipped, err := store.ImportArticles(r.Context(), articles)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to import articles")
+		slog.ErrorContext(r.Context(), "Error importing articles", "error", err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Imported articles", "inserted", inserted, "skipped", skipped)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status": "success", "inserted": %d, "skipped": %d}`, inserted, skipped)
+}
+
+// healthHandler is the liveness probe. It only says the process is up and
+// serving, so a database outage doesn't get the process restarted. /ready
+// checks the database.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintf(w, `{"status": "healthy", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
+}
+
+// ReadyStatus is the response to /ready
+type ReadyStatus struct {
+	Status        string    `json:"status"`
+	Database      string    `json:"database"`
+	DatabaseSince time.Time `json:"database_since"`
+	Templates     string    `json:"templates"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// templatesLoaded reports whether the home page template is available to render
+func templatesLoaded() bool {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return templates != nil && templates.Lookup("home.html") != nil
+}
+
+// readyHandler is the readiness probe. Unlike /health, which only says the process
+// is up, it answers 503 until the database is reachable and the templates are
+// loaded, so a load balancer holds traffic back until requests can be served.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	status := ReadyStatus{Status: "ready", Database: "ok", Templates: "ok"}
+	var errs []error
+	err := store.CheckHealth(r.Context())
+	setDBHealth(r.Context(), err)
+	if err != nil {
+		status.Database = "unavailable"
+		errs = append(errs, err)
+	}
+	dbHealth.mu.Lock()
+	status.DatabaseSince = dbHealth.since
+	dbHealth.mu.Unlock()
+	if !templatesLoaded() {
+		status.Templates = "unavailable"
+		errs = append(errs, errors.New("templates are not loaded"))
+	}
+
+	if len(errs) > 0 {
+		status.Status = "not ready"
+		status.Error = errors.Join(errs...).Error()
+		slog.WarnContext(r.Context(), "Readiness check failed", "error", status.Error)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// versionHandler reports which build is running
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(VersionInfo{Version: version, Commit: commit, BuildDate: buildDate})
+}
+
+func apiDataHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	data := `{
+	"message": "Hello from the API",
+	"timestamp": "%s",
+	"method": "%s"
+}`
+	fmt.Fprintf(w, data, time.Now().Format(time.RFC3339), r.Method)
+}
+
+// MarkReadResult is the response to /mark-read, carrying the article's resulting
+// state so optimistic clients can reconcile
+type MarkReadResult struct {
+	Status string     `json:"status"`
+	ID     int        `json:"id"`
+	Read   bool       `json:"read"`
+	ReadAt *time.Time `json:"read_at"`
+}
+
+// markReadHandler sets (never toggles) an article's read state from the id and
+// read params, which may be in the query string or a form-encoded body. With
+// beacon=true it answers 204 with no body, for navigator.sendBeacon which never
+// reads the response.
+func markReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, 1<<10)
+	idStr := r.FormValue("id")
+	readStr := r.FormValue("read")
+
+	if idStr == "" || readStr == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing id or read parameter")
+		return
+	}
+
+	id := 0
+	fmt.Sscanf(idStr, "%d", &id)
+	read := readStr == "true"
+
+	updated, err := store.MarkRead(r.Context(), id, read)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update article")
+		slog.ErrorContext(r.Context(), "Error updating article", "error", err, "id", id)
+		return
+	}
+	if updated == 0 {
+		writeJSONError(w, http.StatusNotFound, "Article not found")
+		return
+	}
+
+	if r.FormValue("beacon") == "true" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	article, err := store.GetArticle(r.Context(), id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch article")
+		slog.ErrorContext(r.Context(), "Error fetching article", "error", err, "id", id)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MarkReadResult{Status: "success", ID: article.ID, Read: article.Read, ReadAt: article.ReadAt})
+}
+
+// Most IDs accepted by one bulk mark-read request
+const maxBulkMarkIDs = 1000
+
+// bulkMarkReadHandler handles POST /mark-read/bulk with a body like
+// {"ids": [1, 2, 3], "read": true}
+func bulkMarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IDs  []json.RawMessage `json:"ids"`
+		Read *bool             `json:"read"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, `Invalid request, expected {"ids": [...], "read": true|false}`)
+		return
+	}
+	if req.Read == nil {
+		writeJSONError(w, http.StatusBadRequest, "Missing read field")
+		return
+	}
+	if len(req.IDs) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "ids must not be empty")
+		return
+	}
+	if len(req.IDs) > maxBulkMarkIDs {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("Too many ids, at most %d are allowed", maxBulkMarkIDs))
+		return
+	}
+
+	// Every id is checked before anything is updated, and repeats are only applied once
+	ids := make([]int, 0, len(req.IDs))
+	seen := make(map[int]bool, len(req.IDs))
+	for i, raw := range req.IDs {
+		id, err := strconv.Atoi(string(raw))
+		if err != nil || id < 1 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ids[%d] is not a valid article id: %s", i, raw))
+			return
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	updated, err := store.MarkManyRead(r.Context(), ids, *req.Read)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update articles")
+		slog.ErrorContext(r.Context(), "Error bulk updating articles", "error", err, "ids", len(ids))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status": "success", "updated": %d}`, updated)
+}
+
+// articleUpdateHandler returns a handler for POST /articles/{id}/... endpoints that
+// apply update to the article and respond with its new state
+func articleUpdateHandler(update func(ctx context.Context, id int) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid article id")
+			return
+		}
+
+		updated, err := update(r.Context(), id)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to update article")
+			slog.ErrorContext(r.Context(), "Error updating article", "error", err, "id", id)
+			return
+		}
+		if updated == 0 {
+			writeJSONError(w, http.StatusNotFound, "Article not found")
+			return
+		}
+
+		article, err := store.GetArticle(r.Context(), id)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch article")
+			slog.ErrorContext(r.Context(), "Error fetching article", "error", err, "id", id)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(article)
+	}
+}
+
+// deleteArticleHandler handles DELETE /articles/{id}
+func deleteArticleHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid article id")
+		return
+	}
+
+	deleted, err := store.DeleteArticle(r.Context(), id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete article")
+		slog.ErrorContext(r.Context(), "Error deleting article", "error", err, "id", id)
+		return
+	}
+	if deleted == 0 {
+		writeJSONError(w, http.StatusNotFound, "Article not found")
+		return
 	}
+	slog.InfoContext(r.Context(), "Deleted article", "id", id)
 
-	return rowsAffected > 0, nil
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"status": "deleted", "id": %d}`, id)
+}
+
+// setArticleReadHandler returns a handler for POST /articles/{id}/read and /unread
+func setArticleReadHandler(read bool) http.HandlerFunc {
+	return articleUpdateHandler(func(ctx context.Context, id int) (int64, error) {
+		return store.MarkRead(ctx, id, read)
+	})
 }
 
-// processFeed fetches and processes the RSS feed
-func processFeed() {
-	slog.Info("Starting RSS feed processing")
+// laterArticleHandler handles POST /articles/{id}/later. Marking the article read
+// or unread takes it back out of the queue.
+var laterArticleHandler = articleUpdateHandler(func(ctx context.Context, id int) (int64, error) {
+	return store.MarkLater(ctx, id)
+})
+
+// toggleArticleReadHandler handles POST /articles/{id}/toggle-read. Unlike the
+// other read endpoints it isn't idempotent, so retries flip the state again.
+var toggleArticleReadHandler = articleUpdateHandler(func(ctx context.Context, id int) (int64, error) {
+	return store.ToggleRead(ctx, id)
+})
 
-	rss, err := fetchAndParseRSS()
+// snoozeArticleHandler handles POST /articles/{id}/snooze?until=..., hiding the
+// article until the given RFC3339 time or YYYY-MM-DD date (midnight UTC)
+func snoozeArticleHandler(w http.ResponseWriter, r *http.Request) {
+	value := r.URL.Query().Get("until")
+	if value == "" {
+		writeJSONError(w, http.StatusBadRequest, "Missing until parameter")
+		return
+	}
+	until, err := parseDateParam(value, false)
 	if err != nil {
-		slog.Error("Error fetching RSS", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-
-	newArticles := 0
-	for i := len(rss.Channel.Items) - 1; i >= 0; i-- {
-		// Process items in reverse order to maintain chronological order
-		item := rss.Channel.Items[i]
-		articles := parseArticlesFromDescription(item.Description, item.PubDate)
-
-		for _, article := range articles {
-			inserted, err := saveArticle(article)
-			if err != nil {
-				slog.Error("Error saving article", "error", err, "title", article.Title)
-			} else if inserted {
-				newArticles++
-			}
-		}
+	if !until.After(time.Now()) {
+		writeJSONError(w, http.StatusBadRequest, "until must be in the future")
+		return
 	}
+	articleUpdateHandler(func(ctx context.Context, id int) (int64, error) {
+		return store.Snooze(ctx, id, until)
+	})(w, r)
+}
 
-	syncTimeMu.Lock()
-	lastSyncTime = time.Now()
-	syncTimeMu.Unlock()
+// unsnoozeArticleHandler handles POST /articles/{id}/unsnooze
+var unsnoozeArticleHandler = articleUpdateHandler(func(ctx context.Context, id int) (int64, error) {
+	return store.Snooze(ctx, id, time.Time{})
+})
 
-	slog.Info("Feed processing complete", "new_articles", newArticles)
+// isAbsoluteHTTPURL reports whether link is a full http(s) URL, which is all
+// that's safe to redirect to from feed data
+func isAbsoluteHTTPURL(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
 }
 
-// getUnreadCount returns the count of unread articles
-func getUnreadCount() (int, error) {
-	var count int
-	err := db.QueryRow(`SELECT COUNT(*) FROM articles WHERE read = 0`).Scan(&count)
-	return count, err
-}
+// nextUnreadHandler handles GET /next?after=<id>. It marks the after article
+// read, if given, and redirects to the oldest unread article, or to the home
+// page once there are none left.
+func nextUnreadHandler(w http.ResponseWriter, r *http.Request) {
+	if v := r.URL.Query().Get("after"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid after parameter, expected an article id", http.StatusBadRequest)
+			return
+		}
+		// An article that's gone doesn't stop the reader moving on
+		if _, err := store.MarkRead(r.Context(), id, true); err != nil {
+			http.Error(w, "Failed to update article", http.StatusInternalServerError)
+			slog.ErrorContext(r.Context(), "Error updating article", "error", err, "id", id)
+			return
+		}
+	}
 
-// getAllArticles retrieves all unread articles from the database
-func getAllArticles() ([]Article, error) {
-	rows, err := db.Query(`
-		SELECT id, date, article_link, comment_link, title, read, created_at
-		FROM articles
-		WHERE read = 0
-		ORDER BY created_at DESC, id DESC
-	`)
+	articles, err := store.ListArticles(r.Context(), ArticleFilter{Read: "false", Sort: "oldest"}, 1, 0)
 	if err != nil {
-		return nil, err
+		http.Error(w, "Failed to fetch articles", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error fetching next unread article", "error", err)
+		return
 	}
-	defer rows.Close()
 
-	var articles []Article
-	for rows.Next() {
-		var a Article
-		var readInt int
-		err := rows.Scan(&a.ID, &a.Date, &a.ArticleLink, &a.CommentLink, &a.Title, &readInt, &a.CreatedAt)
-		if err != nil {
-			return nil, err
+	target := "/"
+	if len(articles) > 0 {
+		for _, link := range []string{articles[0].ArticleLink, articles[0].CommentLink} {
+			if isAbsoluteHTTPURL(link) {
+				target = link
+				break
+			}
 		}
-		a.Read = readInt == 1
-		articles = append(articles, a)
 	}
-
-	return articles, nil
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
 }
 
-// markArticleRead marks an article as read or unread
-func markArticleRead(id int, read bool) error {
-	readInt := 0
-	if read {
-		readInt = 1
-	}
-	_, err := db.Exec(`UPDATE articles SET read = ? WHERE id = ?`, readInt, id)
-	return err
+// setArticleStarredHandler returns a handler for POST /articles/{id}/star and /unstar
+func setArticleStarredHandler(starred bool) http.HandlerFunc {
+	return articleUpdateHandler(func(ctx context.Context, id int) (int64, error) {
+		return store.MarkStarred(ctx, id, starred)
+	})
 }
 
-func addArticleHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func addTagHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid article id")
 		return
 	}
 
 	var req struct {
-		Link string `json:"link"`
+		Tag string `json:"tag"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "Invalid request")
+		return
+	}
+	tag, err := normalizeTag(req.Tag)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid tag: "+err.Error())
 		return
 	}
 
-	id := extractHNID(req.Link)
-	if id == "" {
-		http.Error(w, "Invalid HN link. Please provide a link like https://news.ycombinator.com/item?id=12345", http.StatusBadRequest)
+	if _, err := store.GetArticle(r.Context(), id); err == sql.ErrNoRows {
+		writeJSONError(w, http.StatusNotFound, "Article not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch article")
+		slog.ErrorContext(r.Context(), "Error fetching article", "error", err, "id", id)
 		return
 	}
 
-	article, err := fetchHNItem(id)
-	if err != nil {
-		slog.Error("Error fetching HN item", "error", err, "id", id)
-		http.Error(w, "Failed to fetch HN item: "+err.Error(), http.StatusInternalServerError)
+	if err := store.AddTag(r.Context(), id, tag); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to tag article")
+		slog.ErrorContext(r.Context(), "Error tagging article", "error", err, "id", id, "tag", tag)
 		return
 	}
 
-	inserted, err := saveArticle(article)
+	article, err := store.GetArticle(r.Context(), id)
 	if err != nil {
-		slog.Error("Error saving article", "error", err, "title", article.Title)
-		http.Error(w, "Failed to save article", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch article")
+		slog.ErrorContext(r.Context(), "Error fetching article", "error", err, "id", id)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if inserted {
-		w.WriteHeader(http.StatusCreated)
-		fmt.Fprintf(w, `{"status": "success", "message": "Article added"}`)
-	} else {
-		// Article exists, mark it as unread and update timestamp so it shows up at the top
-		err := markArticleUnreadByLinks(article)
-		if err != nil {
-			slog.Error("Error updating existing article", "error", err, "link", article.ArticleLink)
-			http.Error(w, "Failed to update existing article", http.StatusInternalServerError)
-			return
-		}
-		fmt.Fprintf(w, `{"status": "success", "message": "Article brought back to top"}`)
-	}
-}
-
-func markArticleUnreadByLinks(article Article) error {
-	_, err := db.Exec(`
-		UPDATE articles 
-		SET read = 0, date = ?, created_at = CURRENT_TIMESTAMP 
-		WHERE article_link = ? AND comment_link = ?
-	`, article.Date, article.ArticleLink, article.CommentLink)
-	return err
+	json.NewEncoder(w).Encode(article)
 }
 
-func extractHNID(link string) string {
-	if strings.Contains(link, "id=") {
-		parts := strings.Split(link, "id=")
-		if len(parts) > 1 {
-			id := parts[1]
-			if end := strings.IndexAny(id, "&/ "); end != -1 {
-				id = id[:end]
-			}
-			return id
-		}
+func removeTagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := normalizeTag(r.PathValue("tag"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid tag: "+err.Error())
+		return
 	}
-	return ""
+
+	articleUpdateHandler(func(ctx context.Context, id int) (int64, error) {
+		return store.RemoveTag(ctx, id, tag)
+	})(w, r)
 }
 
-func fetchHNItem(id string) (Article, error) {
-	url := fmt.Sprintf("https://hn.algolia.com/api/v1/items/%s", id)
-	resp, err := httpClient.Get(url)
+func tagHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := normalizeTag(r.PathValue("tag"))
 	if err != nil {
-		return Article{}, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return Article{}, fmt.Errorf("HN API returned status %d", resp.StatusCode)
+		writeJSONError(w, http.StatusBadRequest, "Invalid tag: "+err.Error())
+		return
 	}
 
-	var item struct {
-		Title string `json:"title"`
-		URL   string `json:"url"`
+	articles, err := store.ArticlesByTag(r.Context(), tag)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch articles")
+		slog.ErrorContext(r.Context(), "Error fetching tagged articles", "error", err, "tag", tag)
+		return
 	}
-	if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
-		return Article{}, err
+	if articles == nil {
+		articles = []Article{}
 	}
 
-	if item.Title == "" {
-		return Article{}, fmt.Errorf("could not find title for item %s", id)
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(articles)
+}
 
-	articleLink := item.URL
-	commentLink := fmt.Sprintf("https://news.ycombinator.com/item?id=%s", id)
-	if articleLink == "" {
-		articleLink = commentLink
+func readerHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid article id", http.StatusBadRequest)
+		return
 	}
 
-	return Article{
-		Title:       item.Title,
-		ArticleLink: articleLink,
-		CommentLink: commentLink,
-		Date:        time.Now().Format(time.RFC1123Z),
-	}, nil
-}
-
-// Handler functions
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
+	article, err := store.GetArticle(r.Context(), id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Article not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to fetch article", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error fetching article", "error", err, "id", id)
 		return
 	}
 
-	articles, err := getAllArticles()
+	content, err := store.GetContent(r.Context(), id)
 	if err != nil {
-		slog.Error("Error fetching articles", "error", err)
-		articles = []Article{}
+		http.Error(w, "Failed to fetch article", http.StatusInternalServerError)
+		slog.ErrorContext(r.Context(), "Error fetching article content", "error", err, "id", id)
+		return
 	}
 
-	syncTimeMu.RLock()
-	syncTime := lastSyncTime
-	syncTimeMu.RUnlock()
-
-	data := TemplateData{
-		Title:        "HN Reader",
-		LastSyncTime: syncTime,
-		Articles:     articles,
+	data := ReaderData{SiteTitle: siteTitle, Article: article}
+	if content != "" {
+		data.Paragraphs = strings.Split(content, "\n\n")
 	}
 
-	if err := templates.ExecuteTemplate(w, "home.html", data); err != nil {
+	body, err := renderTemplate("reader.html", data)
+	if err != nil {
 		http.Error(w, "Error rendering template", http.StatusInternalServerError)
-		slog.Error("Template error", "error", err)
+		slog.ErrorContext(r.Context(), "Template error", "error", err)
+		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(body)
 }
 
-func syncHandler(w http.ResponseWriter, r *http.Request) {
-	// Run the feed processing asynchronously
-	go processFeed()
+func starredHandler(w http.ResponseWriter, r *http.Request) {
+	articles, err := store.StarredArticles(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch articles")
+		slog.ErrorContext(r.Context(), "Error fetching starred articles", "error", err)
+		return
+	}
+	if articles == nil {
+		articles = []Article{}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"status": "sync started", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
+	json.NewEncoder(w).Encode(articles)
 }
 
-func healthHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"status": "healthy", "timestamp": "%s"}`, time.Now().Format(time.RFC3339))
-}
+func laterHandler(w http.ResponseWriter, r *http.Request) {
+	articles, err := store.LaterArticles(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch articles")
+		slog.ErrorContext(r.Context(), "Error fetching read later articles", "error", err)
+		return
+	}
+	if articles == nil {
+		articles = []Article{}
+	}
 
-func apiDataHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	data := `{
-	"message": "Hello from the API",
-	"timestamp": "%s",
-	"method": "%s"
-}`
-	fmt.Fprintf(w, data, time.Now().Format(time.RFC3339), r.Method)
+	json.NewEncoder(w).Encode(articles)
 }
 
-func markReadHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+func recentlyReadHandler(w http.ResponseWriter, r *http.Request) {
+	minutes := defaultRecentlyReadMinutes
+	if minutesStr := r.URL.Query().Get("minutes"); minutesStr != "" {
+		var err error
+		minutes, err = strconv.Atoi(minutesStr)
+		if err != nil || minutes < 1 {
+			writeJSONError(w, http.StatusBadRequest, "Invalid minutes parameter, expected a positive integer")
+			return
+		}
+	}
+
+	articles, err := store.RecentlyRead(r.Context(), time.Now().Add(-time.Duration(minutes)*time.Minute))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch articles")
+		slog.ErrorContext(r.Context(), "Error fetching recently read articles", "error", err)
 		return
 	}
+	if articles == nil {
+		articles = []Article{}
+	}
 
-	idStr := r.URL.Query().Get("id")
-	readStr := r.URL.Query().Get("read")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(articles)
+}
 
-	if idStr == "" || readStr == "" {
-		http.Error(w, "Missing id or read parameter", http.StatusBadRequest)
+func markAllReadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	id := 0
-	fmt.Sscanf(idStr, "%d", &id)
-	read := readStr == "true"
+	var before time.Time
+	if beforeStr := r.URL.Query().Get("before"); beforeStr != "" {
+		var err error
+		before, err = time.Parse(time.RFC3339, beforeStr)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "Invalid before parameter, expected RFC3339 timestamp")
+			return
+		}
+	}
 
-	err := markArticleRead(id, read)
+	updated, err := store.MarkAllRead(r.Context(), before)
 	if err != nil {
-		http.Error(w, "Failed to update article", http.StatusInternalServerError)
-		slog.Error("Error updating article", "error", err, "id", id)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to update articles")
+		slog.ErrorContext(r.Context(), "Error marking all articles read", "error", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintf(w, `{"status": "success"}`)
+	fmt.Fprintf(w, `{"status": "success", "updated": %d}`, updated)
 }
 
 func main() {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON config file")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		slog.Error("Failed to load configuration", "error", err, "path", *configPath)
+		os.Exit(1)
+	}
+	logLevel, _ := parseLogLevel(cfg.LogLevel)
+
 	// Initialize structured logger
-	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	})))
+	logOptions := &slog.HandlerOptions{Level: logLevel}
+	var logHandler slog.Handler = slog.NewTextHandler(os.Stdout, logOptions)
+	if cfg.LogFormat == logFormatJSON {
+		logHandler = slog.NewJSONHandler(os.Stdout, logOptions)
+	}
+	slog.SetDefault(slog.New(requestIDHandler{logHandler}))
+
+	slog.Info("Starting web server", "version", version, "commit", commit, "build_date", buildDate)
+	if *configPath != "" {
+		slog.Info("Loaded config file", "path", *configPath)
+	}
+
+	dedupBy = cfg.DedupBy
+	refreshCounts = cfg.RefreshCounts
+	hideSeen = cfg.HideSeen
+	slog.Info("Deduplicating articles", "by", dedupBy, "refresh_counts", refreshCounts || dedupBy == dedupByLink, "hide_seen", hideSeen)
+
+	contentFetchEnabled = cfg.FetchContent
+	if contentFetchEnabled {
+		slog.Info("Fetching readable content for new articles")
+	}
+
+	storeRawItems = cfg.StoreRawItems
+	slog.Info("Raw feed items", "stored", storeRawItems, "retention_days", cfg.RawItemDays)
+
+	if cfg.SMTPHost != "" {
+		digestEnabled = true
+		smtpSettings.host = cfg.SMTPHost
+		smtpSettings.port = cfg.SMTPPort
+		smtpSettings.user = cfg.SMTPUser
+		smtpSettings.pass = cfg.SMTPPass
+		smtpSettings.from = cfg.SMTPFrom
+		smtpSettings.to = cfg.SMTPTo
+		smtpSettings.schedule = cfg.DigestSchedule
+		slog.Info("Email digests enabled", "host", cfg.SMTPHost, "to", cfg.SMTPTo, "schedule", cfg.DigestSchedule)
+	}
+
+	syncTimeout = cfg.SyncTimeout.Duration
+	manualSyncLimiter.interval = cfg.SyncRateLimit.Duration
+	if manualSyncLimiter.interval > 0 {
+		slog.Info("Manual syncs rate limited", "interval", manualSyncLimiter.interval)
+	}
+
+	userAgent = cfg.UserAgent
+	maxFeedBytes = cfg.MaxFeedBytes
+	slog.Info("Outbound requests", "user_agent", userAgent)
 
-	slog.Info("Starting web server")
+	siteTitle = cfg.SiteTitle
+	siteSubtitle = cfg.SiteSubtitle
+
+	webhookURL = cfg.WebhookURL
+	if webhookURL != "" {
+		slog.Info("Webhook notifications enabled")
+	}
+
+	devMode = cfg.Dev
+	if devMode {
+		slog.Info("Dev mode enabled, templates are reloaded on every request")
+	}
+
+	homeCacheEnabled = cfg.Cache == "on" && !devMode
+	if !homeCacheEnabled {
+		slog.Info("Home page cache disabled")
+	}
+
+	// Feed configuration
+	feedURLs = cfg.FeedURLs
+	slog.Info("Using feeds", "urls", feedURLs)
+
+	authUser = cfg.AuthUser
+	authPass = cfg.AuthPass
+	if authUser != "" {
+		slog.Info("Basic auth enabled", "user", authUser)
+	}
+
+	// HTML pages only need the token when API_TOKEN_PAGES is set
+	pageAuth := authMiddleware
+	apiToken = cfg.APIToken
+	if apiToken != "" {
+		slog.Info("API token auth enabled")
+		if cfg.APITokenPages {
+			pageAuth = apiAuthMiddleware
+			slog.Info("API token required for HTML pages")
+		}
+	}
+
+	// Tracing has to be set up before the database is opened so queries get spans
+	shutdownTracing, err := setupTracing(backgroundCtx)
+	if err != nil {
+		slog.Error("Failed to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("OpenTelemetry tracing", "enabled", tracingEnabled)
 
 	// Initialize database
-	if err := initDB(); err != nil {
+	if err := initDB(cfg); err != nil {
 		slog.Error("Failed to initialize database", "error", err)
 		os.Exit(1)
 	}
 	defer db.Close()
 
+	if err := loadLastSyncTime(backgroundCtx); err != nil {
+		slog.Warn("Failed to load last sync time", "error", err)
+	}
+	if digestEnabled {
+		if err := loadLastDigestSent(backgroundCtx); err != nil {
+			slog.Warn("Failed to load last digest time", "error", err)
+		}
+	}
+
 	// Load templates
+	if cfg.AssetsDir != "" {
+		if missing := missingAssetDirs(cfg.AssetsDir); len(missing) > 0 {
+			slog.Warn("Assets dir is incomplete, using the built-in templates and static files", "dir", cfg.AssetsDir, "missing", missing)
+		} else {
+			assets = os.DirFS(cfg.AssetsDir)
+			slog.Info("Serving templates and static files from disk", "dir", cfg.AssetsDir)
+		}
+	}
 	if err := loadTemplates(); err != nil {
 		slog.Error("Failed to load templates", "error", err)
 		os.Exit(1)
 	}
+	slog.Info("Templates loaded successfully")
 
 	// Serve static files (favicons, etc.)
-	fileServer := http.FileServer(http.Dir("static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fileServer))
-
-	// Register routes with logging middleware
-	http.HandleFunc("/", loggingMiddleware(homeHandler))
-	http.HandleFunc("/sync", loggingMiddleware(syncHandler))
-	http.HandleFunc("/add-article", loggingMiddleware(addArticleHandler))
-	http.HandleFunc("/mark-read", loggingMiddleware(markReadHandler))
+	staticFiles, err := fs.Sub(assets, "static")
+	if err != nil {
+		slog.Error("Failed to open static files", "error", err)
+		os.Exit(1)
+	}
+	fileServer := http.FileServerFS(staticFiles)
+	// Dev mode serves edits straight away, like it does for templates
+	staticMaxAge := cfg.StaticMaxAge.Duration
+	if devMode {
+		staticMaxAge = 0
+	}
+	http.Handle("/static/", staticCacheMiddleware(staticMaxAge, http.StripPrefix("/static/", fileServer)))
+	slog.Info("Static file caching", "max_age", staticMaxAge)
+
+	// Register routes with logging middleware. Everything except /health, /ready,
+	// /version, /metrics and static assets requires auth when it's configured. The JSON
+	// API and anything that changes data also accept the API token. Listings and
+	// exports are gzipped for clients that support it.
+	http.HandleFunc("/", loggingMiddleware(gzipMiddleware(pageAuth(homeHandler))))
+	http.HandleFunc("/sync", loggingMiddleware(apiAuthMiddleware(syncHandler)))
+	http.HandleFunc("GET /sync/status", loggingMiddleware(apiAuthMiddleware(syncStatusHandler)))
+	http.HandleFunc("GET /api/sync-history", loggingMiddleware(apiAuthMiddleware(syncHistoryHandler)))
+	http.HandleFunc("GET /sync/dry-run", loggingMiddleware(apiAuthMiddleware(syncDryRunHandler)))
+	http.HandleFunc("POST /admin/reparse", loggingMiddleware(apiAuthMiddleware(reparseHandler)))
+	http.HandleFunc("POST /admin/vacuum", loggingMiddleware(apiAuthMiddleware(vacuumHandler)))
+	http.HandleFunc("/add-article", loggingMiddleware(apiAuthMiddleware(addArticleHandler)))
+	http.HandleFunc("/mark-read", loggingMiddleware(apiAuthMiddleware(markReadHandler)))
+	http.HandleFunc("POST /mark-read/bulk", loggingMiddleware(apiAuthMiddleware(bulkMarkReadHandler)))
+	http.HandleFunc("/mark-all-read", loggingMiddleware(apiAuthMiddleware(markAllReadHandler)))
+	http.HandleFunc("/recently-read", loggingMiddleware(gzipMiddleware(pageAuth(recentlyReadHandler))))
+	http.HandleFunc("POST /articles/{id}/star", loggingMiddleware(apiAuthMiddleware(setArticleStarredHandler(true))))
+	http.HandleFunc("POST /articles/{id}/unstar", loggingMiddleware(apiAuthMiddleware(setArticleStarredHandler(false))))
+	http.HandleFunc("DELETE /articles/{id}", loggingMiddleware(apiAuthMiddleware(deleteArticleHandler)))
+	http.HandleFunc("GET /articles/{id}/reader", loggingMiddleware(pageAuth(readerHandler)))
+	http.HandleFunc("GET /favicon-badge.svg", loggingMiddleware(pageAuth(faviconBadgeHandler)))
+	http.HandleFunc("/starred", loggingMiddleware(gzipMiddleware(pageAuth(starredHandler))))
+	http.HandleFunc("POST /articles/{id}/tags", loggingMiddleware(apiAuthMiddleware(addTagHandler)))
+	http.HandleFunc("DELETE /articles/{id}/tags/{tag}", loggingMiddleware(apiAuthMiddleware(removeTagHandler)))
+	http.HandleFunc("GET /tags/{tag}", loggingMiddleware(gzipMiddleware(pageAuth(tagHandler))))
+	http.HandleFunc("POST /articles/{id}/read", loggingMiddleware(apiAuthMiddleware(setArticleReadHandler(true))))
+	http.HandleFunc("POST /articles/{id}/unread", loggingMiddleware(apiAuthMiddleware(setArticleReadHandler(false))))
+	http.HandleFunc("POST /articles/{id}/toggle-read", loggingMiddleware(apiAuthMiddleware(toggleArticleReadHandler)))
+	http.HandleFunc("POST /articles/{id}/later", loggingMiddleware(apiAuthMiddleware(laterArticleHandler)))
+	http.HandleFunc("GET /next", loggingMiddleware(apiAuthMiddleware(nextUnreadHandler)))
+	http.HandleFunc("POST /articles/{id}/snooze", loggingMiddleware(apiAuthMiddleware(snoozeArticleHandler)))
+	http.HandleFunc("POST /articles/{id}/unsnooze", loggingMiddleware(apiAuthMiddleware(unsnoozeArticleHandler)))
+	http.HandleFunc("/later", loggingMiddleware(gzipMiddleware(pageAuth(laterHandler))))
+	http.HandleFunc("/search", loggingMiddleware(gzipMiddleware(pageAuth(searchHandler))))
 	http.HandleFunc("/health", loggingMiddleware(healthHandler))
-	http.HandleFunc("/api/data", loggingMiddleware(apiDataHandler))
+	http.HandleFunc("GET /ready", loggingMiddleware(readyHandler))
+	http.HandleFunc("GET /version", loggingMiddleware(versionHandler))
+	http.HandleFunc("/api/data", loggingMiddleware(apiAuthMiddleware(apiDataHandler)))
+	http.HandleFunc("/api/articles", loggingMiddleware(gzipMiddleware(apiAuthMiddleware(apiArticlesHandler))))
+	http.HandleFunc("GET /api/links", loggingMiddleware(apiAuthMiddleware(linksHandler)))
+	http.HandleFunc("GET /api/hosts", loggingMiddleware(apiAuthMiddleware(hostsHandler)))
+	http.HandleFunc("/api/unread-count", loggingMiddleware(apiAuthMiddleware(unreadCountHandler)))
+	http.HandleFunc("GET /api/state", loggingMiddleware(apiAuthMiddleware(stateHandler)))
+	http.HandleFunc("/feed.xml", loggingMiddleware(gzipMiddleware(pageAuth(feedHandler))))
+	http.HandleFunc("/feed.json", loggingMiddleware(gzipMiddleware(pageAuth(jsonFeedHandler))))
+	http.HandleFunc("/export/opml", loggingMiddleware(gzipMiddleware(pageAuth(opmlExportHandler))))
+	http.HandleFunc("/export/csv", loggingMiddleware(gzipMiddleware(pageAuth(csvExportHandler))))
+	http.HandleFunc("/export/json", loggingMiddleware(gzipMiddleware(pageAuth(jsonExportHandler))))
+	http.HandleFunc("/import/json", loggingMiddleware(apiAuthMiddleware(jsonImportHandler)))
 
-	// Server configuration
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Metrics are on by default, set METRICS_ENABLED=false to hide /metrics
+	if cfg.MetricsEnabled {
+		http.Handle("/metrics", promhttp.Handler())
+		slog.Info("Prometheus metrics enabled", "path", "/metrics")
 	}
-	addr := fmt.Sprintf(":%s", port)
+
+	// Server configuration
+	addr := net.JoinHostPort(cfg.Host, cfg.Port)
 
 	// Create HTTP server
 	server := &http.Server{
 		Addr:         addr,
 		Handler:      nil,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+	}
+	var handler http.Handler = http.DefaultServeMux
+	if len(cfg.CORSOrigins) > 0 {
+		handler = corsMiddleware(cfg.CORSOrigins, handler)
+		slog.Info("CORS enabled for the API", "origins", cfg.CORSOrigins)
 	}
+	if tracingEnabled {
+		handler = otelhttp.NewHandler(handler, "http.request")
+	}
+	server.Handler = handler
+	slog.Info("HTTP timeouts", "read", server.ReadTimeout, "write", server.WriteTimeout, "idle", server.IdleTimeout)
+
+	// Load the certificate now so a bad pair fails at startup, not on the first handshake.
+	// ListenAndServeTLS turns on HTTP/2 by itself
+	useTLS := cfg.TLSCert != ""
+	if useTLS {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			slog.Error("Failed to load TLS certificate", "cert", cfg.TLSCert, "key", cfg.TLSKey, "error", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+	slog.Info("TLS", "enabled", useTLS)
 
-	// Start automatic refresh ticker (every 2 hours)
-	ticker := time.NewTicker(2 * time.Hour)
+	// Start automatic refresh ticker
+	ticker := time.NewTicker(cfg.RefreshInterval.Duration)
 	defer ticker.Stop()
 
-	go func() {
-		for range ticker.C {
+	runInBackground(func() {
+		for {
+			select {
+			case <-ticker.C:
+			case <-backgroundCtx.Done():
+				return
+			}
+			if !tryStartSync() {
+				slog.Info("Skipping automatic feed refresh, sync already running")
+				continue
+			}
 			slog.Info("Automatic feed refresh triggered")
-			processFeed()
+			processFeed(backgroundCtx)
+			finishSync()
 		}
-	}()
+	})
+
+	// Prune old read articles at startup and then daily
+	pruneTicker := time.NewTicker(pruneInterval)
+	defer pruneTicker.Stop()
+
+	runInBackground(func() {
+		for {
+			runPrune(backgroundCtx, cfg.RetentionDays, cfg.PruneMode)
+			runRawItemPrune(backgroundCtx, cfg.RawItemDays)
+			if cfg.VacuumAfterPrune {
+				runVacuum(backgroundCtx)
+			}
+			select {
+			case <-pruneTicker.C:
+			case <-backgroundCtx.Done():
+				return
+			}
+		}
+	})
+
+	// Watch the database so outages are logged and recovered from
+	runInBackground(func() { monitorDB(backgroundCtx) })
+
+	// Keep the unread gauge current between syncs
+	refreshUnreadGauge(backgroundCtx)
+	unreadTicker := time.NewTicker(unreadGaugeInterval)
+	defer unreadTicker.Stop()
+
+	runInBackground(func() {
+		for {
+			select {
+			case <-unreadTicker.C:
+				refreshUnreadGauge(backgroundCtx)
+			case <-backgroundCtx.Done():
+				return
+			}
+		}
+	})
+
+	// Bring snoozed articles back on the home page once their time comes
+	snoozeTicker := time.NewTicker(snoozeCheckInterval)
+	defer snoozeTicker.Stop()
+
+	runInBackground(func() {
+		for {
+			select {
+			case <-snoozeTicker.C:
+				store.WakeSnoozed(backgroundCtx)
+			case <-backgroundCtx.Done():
+				return
+			}
+		}
+	})
 
 	// Setup graceful shutdown
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
+	// Closed once requests and background work have finished, so the deferred
+	// db.Close can't run while anything is still writing
+	stopped := make(chan struct{})
+
 	go func() {
 		sig := <-shutdown
 		slog.Info("Shutdown signal received", "signal", sig)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// Stop any in-flight sync or prune before waiting on requests
+		cancelBackground()
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
 
 		if err := server.Shutdown(ctx); err != nil {
 			slog.Error("Server shutdown error", "error", err)
 			os.Exit(1)
 		}
+
+		// Requests are done, so nothing new can be started in the background
+		if waitForBackground(ctx) {
+			slog.Info("Background work finished")
+		} else {
+			slog.Warn("Timed out waiting for background work to finish")
+		}
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+		close(stopped)
 	}()
 
-	slog.Info("Server listening", "address", "http://localhost"+addr)
-	slog.Info("Automatic feed refresh enabled", "interval", "2 hours")
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	slog.Info("Server listening", "bind_address", addr, "address", scheme+"://"+net.JoinHostPort(displayHost(cfg.Host), cfg.Port))
+	slog.Info("Automatic feed refresh enabled", "interval", cfg.RefreshInterval.Duration)
 
 	// Start server
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if useTLS {
+		// The certificate is already in TLSConfig
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
 		slog.Error("Server failed to start", "error", err)
 		os.Exit(1)
 	}
 
+	<-stopped
 	slog.Info("Server stopped gracefully")
 }
@@ -0,0 +1,1922 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+	if err := loadTemplates(); err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to load templates:", err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestDB opens an empty in-memory database for the test, closed when it ends
+func newTestDB(t *testing.T) {
+	t.Helper()
+	cfg := defaultConfig()
+	cfg.DBPath = inMemoryDBPath
+	if err := initDB(cfg); err != nil {
+		t.Fatalf("initDB: %v", err)
+	}
+	invalidateHomeCache()
+	t.Cleanup(func() {
+		db.Close()
+		invalidateHomeCache()
+	})
+}
+
+// seedArticles saves articles and returns them with their IDs filled in
+func seedArticles(t *testing.T, articles ...Article) []Article {
+	t.Helper()
+	inserted, err := store.SaveArticles(t.Context(), articles)
+	if err != nil {
+		t.Fatalf("SaveArticles: %v", err)
+	}
+	return inserted
+}
+
+// testArticle returns an unread article with n points and links derived from title
+func testArticle(n int, title string) Article {
+	return Article{
+		Date:        "2024-01-02",
+		ArticleLink: "https://example.com/" + title,
+		CommentLink: "https://news.ycombinator.com/item?id=" + title,
+		Title:       title,
+		Source:      defaultFeedURL,
+		Points:      n,
+	}
+}
+
+// testFeed is a standard RSS feed with two stories
+const testFeed = `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>Test feed</title>
+<item><title>First story</title><link>https://example.com/first</link><pubDate>Wed, 03 Jan 2024 10:00:00 +0000</pubDate><comments>https://news.ycombinator.com/item?id=101</comments></item>
+<item><title>Second story</title><link>https://example.com/second</link><pubDate>Wed, 03 Jan 2024 09:00:00 +0000</pubDate><comments>https://news.ycombinator.com/item?id=102</comments></item>
+</channel></rss>`
+
+// useTestFeed writes body to a file and syncs from it for the rest of the test
+func useTestFeed(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "feed.rss")
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	feedURL := "file://" + path
+	saved := feedURLs
+	feedURLs = []string{feedURL}
+	t.Cleanup(func() { feedURLs = saved })
+	return feedURL
+}
+
+// serve runs a request through handler and returns the recorded response
+func serve(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	return rec
+}
+
+// withPathValues sets the wildcards the mux would have matched, in name, value pairs
+func withPathValues(req *http.Request, pairs ...string) *http.Request {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		req.SetPathValue(pairs[i], pairs[i+1])
+	}
+	return req
+}
+
+// postForm builds a POST request with a form-encoded body
+func postForm(target string, form url.Values) *http.Request {
+	req := httptest.NewRequest("POST", target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// articleTitles lists the titles of articles in order
+func articleTitles(articles []Article) []string {
+	titles := []string{}
+	for _, a := range articles {
+		titles = append(titles, a.Title)
+	}
+	return titles
+}
+
+// listTitles fetches a JSON article listing and returns the titles in it
+func listTitles(t *testing.T, handler http.HandlerFunc, target string) []string {
+	t.Helper()
+	rec := serve(handler, httptest.NewRequest("GET", target, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: status = %d: %s", target, rec.Code, rec.Body)
+	}
+	var articles []Article
+	decodeJSON(t, rec, &articles)
+	return articleTitles(articles)
+}
+
+// decodeJSON unmarshals a response body into v
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func TestSearchHandler(t *testing.T) {
+	newTestDB(t)
+	if !store.SearchEnabled() {
+		t.Skip("SQLite built without FTS5, run with -tags sqlite_fts5")
+	}
+	seedArticles(t, testArticle(1, "Rust compiler internals"), testArticle(2, "Go scheduler deep dive"))
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		titles []string
+	}{
+		{"hit", "scheduler", http.StatusOK, []string{"Go scheduler deep dive"}},
+		{"miss", "haskell", http.StatusOK, []string{}},
+		{"operators are literal", `compiler OR "`, http.StatusOK, []string{}},
+		{"empty", "  ", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/search?q="+url.QueryEscape(tt.query), nil)
+			rec := serve(searchHandler, req)
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.titles == nil {
+				return
+			}
+			var articles []Article
+			decodeJSON(t, rec, &articles)
+			if titles := articleTitles(articles); !slices.Equal(titles, tt.titles) {
+				t.Errorf("titles = %q, want %q", titles, tt.titles)
+			}
+		})
+	}
+}
+
+func TestSyncHandlerRejectsOverlappingSyncs(t *testing.T) {
+	newTestDB(t)
+	useTestFeed(t, testFeed)
+
+	tests := []struct {
+		name    string
+		running bool
+		status  int
+	}{
+		{"sync already running", true, http.StatusConflict},
+		{"idle", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.running {
+				if !tryStartSync() {
+					t.Fatal("sync slot already taken")
+				}
+				defer finishSync()
+			}
+			rec := serve(syncHandler, httptest.NewRequest("POST", "/sync?wait=true", nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if !tt.running && !tryStartSync() {
+				t.Fatal("sync slot not released after the sync")
+			}
+			finishSync()
+		})
+	}
+}
+
+func TestGetWithRetry(t *testing.T) {
+	saved := fetchRetryBaseDelay
+	fetchRetryBaseDelay = time.Millisecond
+	t.Cleanup(func() { fetchRetryBaseDelay = saved })
+
+	tests := []struct {
+		name     string
+		statuses []int // answered in turn, the last one repeating
+		wantErr  bool
+		wantHits int
+	}{
+		{"success", []int{200}, false, 1},
+		{"recovers from 5xx", []int{503, 502, 200}, false, 3},
+		{"gives up after every attempt fails", []int{500}, true, fetchRetryAttempts},
+		{"4xx is not retried", []int{404}, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hits := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[min(hits, len(tt.statuses)-1)])
+				hits++
+			}))
+			defer srv.Close()
+
+			resp, err := getWithRetry(t.Context(), srv.URL, nil)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %t", err, tt.wantErr)
+			}
+			if hits != tt.wantHits {
+				t.Errorf("requests = %d, want %d", hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestMarkReadHandler(t *testing.T) {
+	newTestDB(t)
+	article := seedArticles(t, testArticle(1, "Existing"))[0]
+	id := strconv.Itoa(article.ID)
+
+	tests := []struct {
+		name   string
+		form   url.Values
+		status int
+	}{
+		{"existing article", url.Values{"id": {id}, "read": {"true"}}, http.StatusOK},
+		{"missing article", url.Values{"id": {"9999"}, "read": {"true"}}, http.StatusNotFound},
+		{"missing read", url.Values{"id": {id}}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(markReadHandler, postForm("/mark-read", tt.form))
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+}
+
+func TestFetchAndParseRSSErrors(t *testing.T) {
+	captcha := "<html><body>Please prove you are human" + strings.Repeat(".", 500) + "</body></html>"
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantErr     []string // substrings of the error, none means success
+	}{
+		{"valid feed", "application/rss+xml", testFeed, nil},
+		{"html page", "text/html", captcha, []string{`"text/html"`, "status 200", "Please prove you are human", "..."}},
+		{"malformed xml", "application/xml", "<rss><channel><item>", []string{"failed to parse RSS", "status 200", "<rss><channel><item>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			_, err := fetchAndParseRSS(t.Context(), srv.URL, true)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't mention %q", err, want)
+				}
+			}
+			if strings.Contains(err.Error(), captcha) {
+				t.Error("error includes the whole body")
+			}
+		})
+	}
+}
+
+// storyItem is one Hacker News Daily story with extra markup after the story link
+func storyItem(extra string) string {
+	return `<ul><li><span class="storylink"><a href="https://example.com/a">A story</a></span>` + extra +
+		`<br><span class="postlink"><a href="https://news.ycombinator.com/item?id=1">comments</a></span></li></ul>`
+}
+
+func TestParseCommentCount(t *testing.T) {
+	tests := []struct {
+		name        string
+		description string
+		comments    int
+	}{
+		{"count", storyItem(`<span class="score">412 points</span> <span>57 comments</span>`), 57},
+		{"single comment", storyItem(`<span>1 comment</span>`), 1},
+		{"absent", storyItem(`<span class="score">12 points</span>`), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			articles := parseArticlesFromDescription(tt.description, "", nil)
+			if len(articles) != 1 {
+				t.Fatalf("parsed %d articles, want 1", len(articles))
+			}
+			if articles[0].CommentCount != tt.comments {
+				t.Errorf("comment count = %d, want %d", articles[0].CommentCount, tt.comments)
+			}
+		})
+	}
+
+	t.Run("stored", func(t *testing.T) {
+		newTestDB(t)
+		a := testArticle(1, "Discussed")
+		a.CommentCount = 42
+		saved := seedArticles(t, a)[0]
+		got, err := store.GetArticle(t.Context(), saved.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.CommentCount != 42 {
+			t.Errorf("stored comment count = %d, want 42", got.CommentCount)
+		}
+	})
+}
+
+func TestArticleTags(t *testing.T) {
+	newTestDB(t)
+	tagged := seedArticles(t, testArticle(1, "Tagged"), testArticle(2, "Untagged"))[0]
+	id := strconv.Itoa(tagged.ID)
+
+	addTag := func(id, body string) *http.Request {
+		return withPathValues(httptest.NewRequest("POST", "/articles/"+id+"/tags", strings.NewReader(body)), "id", id)
+	}
+	removeTag := func(id, tag string) *http.Request {
+		return withPathValues(httptest.NewRequest("DELETE", "/articles/"+id+"/tags/"+tag, nil), "id", id, "tag", tag)
+	}
+
+	// Steps run in order against the same database
+	steps := []struct {
+		name    string
+		handler http.HandlerFunc
+		req     *http.Request
+		status  int
+		tags    []string // tags on the article in the response, when checked
+	}{
+		{"add", addTagHandler, addTag(id, `{"tag": "Go"}`), http.StatusOK, []string{"go"}},
+		{"add again in another case", addTagHandler, addTag(id, `{"tag": " GO "}`), http.StatusOK, []string{"go"}},
+		{"add a second tag", addTagHandler, addTag(id, `{"tag": "databases"}`), http.StatusOK, []string{"databases", "go"}},
+		{"empty tag", addTagHandler, addTag(id, `{"tag": " "}`), http.StatusBadRequest, nil},
+		{"missing article", addTagHandler, addTag("9999", `{"tag": "go"}`), http.StatusNotFound, nil},
+		{"remove ignoring case", removeTagHandler, removeTag(id, "Go"), http.StatusOK, []string{"databases"}},
+		{"remove a tag the article doesn't have", removeTagHandler, removeTag(id, "go"), http.StatusNotFound, nil},
+	}
+	for _, step := range steps {
+		rec := serve(step.handler, step.req)
+		if rec.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if step.tags != nil {
+			var article Article
+			decodeJSON(t, rec, &article)
+			if !slices.Equal(article.Tags, step.tags) {
+				t.Errorf("%s: tags = %q, want %q", step.name, article.Tags, step.tags)
+			}
+		}
+	}
+
+	rec := serve(tagHandler, withPathValues(httptest.NewRequest("GET", "/tags/DATABASES", nil), "tag", "DATABASES"))
+	var articles []Article
+	decodeJSON(t, rec, &articles)
+	if len(articles) != 1 || articles[0].ID != tagged.ID {
+		t.Errorf("GET /tags/DATABASES returned %+v, want only article %d", articles, tagged.ID)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	tests := []struct {
+		name   string
+		legacy bool // start from the schema used before migrations existed
+	}{
+		{"empty database", false},
+		{"pre-migration database", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			db, err = sql.Open("sqlite3", inMemoryDBPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.SetMaxOpenConns(1)
+			defer db.Close()
+
+			if tt.legacy {
+				_, err := db.Exec(`
+					CREATE TABLE articles (
+						id INTEGER PRIMARY KEY AUTOINCREMENT,
+						date TEXT NOT NULL,
+						article_link TEXT NOT NULL,
+						comment_link TEXT NOT NULL,
+						title TEXT NOT NULL,
+						read INTEGER DEFAULT 0,
+						created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+						UNIQUE(article_link, comment_link)
+					);
+					INSERT INTO articles (date, article_link, comment_link, title, read)
+					VALUES ('2024-01-01', 'https://example.com/old', 'https://news.ycombinator.com/item?id=1', 'Old story', 1);`)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Running twice checks applied migrations are skipped
+			for range 2 {
+				if err := newSQLiteStore(db).runMigrations(sqliteMigrations); err != nil {
+					t.Fatalf("runMigrations: %v", err)
+				}
+			}
+
+			var applied int
+			if err := db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&applied); err != nil {
+				t.Fatal(err)
+			}
+			if applied != len(sqliteMigrations) {
+				t.Errorf("applied %d migrations, want %d", applied, len(sqliteMigrations))
+			}
+
+			if tt.legacy {
+				var title, status, host string
+				err := db.QueryRow(`SELECT title, status, host FROM articles`).Scan(&title, &status, &host)
+				if err != nil {
+					t.Fatalf("reading the migrated article: %v", err)
+				}
+				if title != "Old story" || status != articleStatusRead || host != "example.com" {
+					t.Errorf("migrated article = %q, %q, %q, want Old story, read, example.com", title, status, host)
+				}
+			}
+		})
+	}
+}
+
+func TestListingQueriesUseIndexes(t *testing.T) {
+	newTestDB(t)
+
+	tests := []struct {
+		name  string
+		read  string
+		sort  string
+		index string
+	}{
+		{"unread oldest first", "false", "oldest", "idx_articles_status_created"},
+		{"unread newest first", "false", "newest", "idx_articles_status_created"},
+		{"read newest first", "true", "newest", "idx_articles_read_created"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := ArticleFilter{Read: tt.read, Sort: tt.sort}
+			where, args, err := filter.where()
+			if err != nil {
+				t.Fatal(err)
+			}
+			orderBy, err := filter.orderBy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := db.Query(`EXPLAIN QUERY PLAN SELECT id FROM articles WHERE `+where+` ORDER BY `+orderBy+` LIMIT 50`, args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rows.Close()
+
+			var plan []string
+			for rows.Next() {
+				var id, parent, unused int
+				var detail string
+				if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+					t.Fatal(err)
+				}
+				plan = append(plan, detail)
+			}
+			joined := strings.Join(plan, "\n")
+			if !strings.Contains(joined, "USING INDEX "+tt.index) {
+				t.Errorf("plan doesn't use %s:\n%s", tt.index, joined)
+			}
+			if strings.Contains(joined, "TEMP B-TREE") {
+				t.Errorf("plan sorts in a temporary b-tree:\n%s", joined)
+			}
+		})
+	}
+}
+
+func TestHomePageCache(t *testing.T) {
+	newTestDB(t)
+	article := seedArticles(t, testArticle(1, "Cached story"))[0]
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(homeHandler, req)
+	}
+
+	first := get("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" || !strings.Contains(first.Body.String(), "Cached story") {
+		t.Fatalf("first render: status %d, ETag %q", first.Code, etag)
+	}
+
+	if rec := get(etag); rec.Code != http.StatusNotModified {
+		t.Errorf("matching If-None-Match: status = %d, want 304", rec.Code)
+	}
+
+	// Writes that skip invalidation aren't seen until something invalidates the cache
+	if _, err := db.Exec(`UPDATE articles SET title = 'Changed behind the cache' WHERE id = ?`, article.ID); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(""); rec.Header().Get("ETag") != etag {
+		t.Errorf("page was rendered again instead of served from the cache")
+	}
+
+	if _, err := store.MarkRead(t.Context(), article.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	rec := get(etag)
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("after marking read: status %d, ETag %q, want a new page", rec.Code, rec.Header().Get("ETag"))
+	}
+	if strings.Contains(rec.Body.String(), "Changed behind the cache") {
+		t.Error("read article is still listed")
+	}
+}
+
+func TestArticlesDateRange(t *testing.T) {
+	newTestDB(t)
+	var articles []Article
+	for i, created := range []string{"2024-03-01T12:00:00Z", "2024-03-07T23:59:59Z", "2024-03-08T00:00:00Z"} {
+		a := testArticle(i, "created "+created[:10])
+		a.CreatedAt, _ = time.Parse(time.RFC3339, created)
+		articles = append(articles, a)
+	}
+	seedArticles(t, articles...)
+
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		titles []string
+	}{
+		{"whole range", "from=2024-03-01&to=2024-03-08", http.StatusOK, []string{"created 2024-03-08", "created 2024-03-07", "created 2024-03-01"}},
+		{"to covers the whole day", "from=2024-03-02&to=2024-03-07", http.StatusOK, []string{"created 2024-03-07"}},
+		{"from only", "from=2024-03-08", http.StatusOK, []string{"created 2024-03-08"}},
+		{"rfc3339", "from=2024-03-01T12:00:01Z&to=2024-03-07T23:59:59Z", http.StatusOK, []string{"created 2024-03-07"}},
+		{"malformed", "from=last-week", http.StatusBadRequest, nil},
+		{"reversed", "from=2024-03-08&to=2024-03-01", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(apiArticlesHandler, httptest.NewRequest("GET", "/api/articles?"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.titles == nil {
+				return
+			}
+			var got []Article
+			decodeJSON(t, rec, &got)
+			if titles := articleTitles(got); !slices.Equal(titles, tt.titles) {
+				t.Errorf("titles = %q, want %q", titles, tt.titles)
+			}
+		})
+	}
+}
+
+func TestAPIAuthMiddleware(t *testing.T) {
+	savedUser, savedPass, savedToken := authUser, authPass, apiToken
+	t.Cleanup(func() { authUser, authPass, apiToken = savedUser, savedPass, savedToken })
+	authUser, authPass = "reader", "hunter2"
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name   string
+		token  string // API_TOKEN
+		auth   func(r *http.Request)
+		status int
+	}{
+		{"no credentials", "secret", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"right token", "secret", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, http.StatusOK},
+		{"wrong token", "secret", func(r *http.Request) { r.Header.Set("Authorization", "Bearer guess") }, http.StatusUnauthorized},
+		{"basic auth", "secret", func(r *http.Request) { r.SetBasicAuth("reader", "hunter2") }, http.StatusOK},
+		{"wrong basic auth", "secret", func(r *http.Request) { r.SetBasicAuth("reader", "guess") }, http.StatusUnauthorized},
+		{"no token configured", "", func(r *http.Request) { r.SetBasicAuth("reader", "hunter2") }, http.StatusOK},
+		{"no token configured without basic auth", "", func(r *http.Request) {}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiToken = tt.token
+			req := httptest.NewRequest("GET", "/api/articles", nil)
+			tt.auth(req)
+			rec := serve(apiAuthMiddleware(ok), req)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestLoadConfigAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{"unset", nil, false},
+		{"basic auth", map[string]string{"AUTH_USER": "reader", "AUTH_PASS": "hunter2"}, false},
+		{"user without pass", map[string]string{"AUTH_USER": "reader"}, true},
+		{"token on pages", map[string]string{"API_TOKEN": "secret", "API_TOKEN_PAGES": "true"}, false},
+		{"pages without a token", map[string]string{"API_TOKEN_PAGES": "true"}, true},
+		{"pages not a bool", map[string]string{"API_TOKEN": "secret", "API_TOKEN_PAGES": "yes"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"AUTH_USER", "AUTH_PASS", "API_TOKEN", "API_TOKEN_PAGES"} {
+				t.Setenv(name, tt.env[name])
+			}
+			cfg, err := loadConfig("")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %t", err, tt.wantErr)
+			}
+			if err == nil && (cfg.AuthUser != tt.env["AUTH_USER"] || cfg.APIToken != tt.env["API_TOKEN"]) {
+				t.Errorf("config = %+v, want the values from the environment", cfg)
+			}
+		})
+	}
+}
+
+func TestWaitForBackground(t *testing.T) {
+	tests := []struct {
+		name     string
+		finishes bool // whether the task ends before the deadline
+		want     bool
+	}{
+		{"work finishes", true, true},
+		{"work outlives the deadline", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			defer func() {
+				if !tt.finishes {
+					close(release)
+				}
+				backgroundWG.Wait()
+			}()
+			runInBackground(func() { <-release })
+			if tt.finishes {
+				close(release)
+			}
+
+			ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+			defer cancel()
+			if got := waitForBackground(ctx); got != tt.want {
+				t.Errorf("waitForBackground = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessFeedStopsWhenCancelled(t *testing.T) {
+	newTestDB(t)
+	useTestFeed(t, testFeed)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := processFeed(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("processFeed error = %v, want context.Canceled", err)
+	}
+	if n, err := store.UnreadCount(t.Context()); err != nil || n != 0 {
+		t.Errorf("unread count = %d, %v, want nothing saved", n, err)
+	}
+}
+
+func TestFeedParserFor(t *testing.T) {
+	daily := Item{Title: "Day 1", Description: storyItem("")}
+	story := Item{Title: "A story", Link: "https://example.com/story", Comments: "https://news.ycombinator.com/item?id=7"}
+	noComments := Item{Title: "No comments", Link: "https://example.com/quiet"}
+
+	// The fields the parsers fill in from the item
+	type parsed struct{ articleLink, commentLink, title string }
+
+	tests := []struct {
+		name     string
+		feedURL  string
+		item     Item
+		parser   string
+		articles []parsed
+	}{
+		{"hn daily by host", "https://www.daemonology.net/hn-daily/index.rss", daily, "daemonology",
+			[]parsed{{"https://example.com/a", "https://news.ycombinator.com/item?id=1", "A story"}}},
+		{"hn daily mirror by markup", "https://mirror.example.com/daily.rss", daily, "daemonology",
+			[]parsed{{"https://example.com/a", "https://news.ycombinator.com/item?id=1", "A story"}}},
+		{"standard feed", "https://news.ycombinator.com/rss", story, "rss",
+			[]parsed{{"https://example.com/story", "https://news.ycombinator.com/item?id=7", "A story"}}},
+		{"standard feed without comments", "https://news.ycombinator.com/rss", noComments, "rss",
+			[]parsed{{"https://example.com/quiet", "https://example.com/quiet", "No comments"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := feedParserFor(tt.feedURL, []Item{tt.item})
+			if name := parserName(parser); name != tt.parser {
+				t.Errorf("parser = %s, want %s", name, tt.parser)
+			}
+			var got []parsed
+			for _, a := range parser.Parse(tt.item, "") {
+				got = append(got, parsed{a.ArticleLink, a.CommentLink, a.Title})
+			}
+			if !slices.Equal(got, tt.articles) {
+				t.Errorf("articles = %+v, want %+v", got, tt.articles)
+			}
+		})
+	}
+}
+
+func TestSyncLimiterAllow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := &syncLimiter{interval: time.Minute}
+
+	tests := []struct {
+		name  string
+		at    time.Duration // after start
+		allow bool
+		wait  time.Duration
+	}{
+		{"first", 0, true, 0},
+		{"second rapid request is throttled", 10 * time.Second, false, 50 * time.Second},
+		{"still throttled", 59 * time.Second, false, time.Second},
+		{"allowed once the interval has passed", time.Minute, true, 0},
+	}
+	for _, tt := range tests {
+		allow, wait := l.allow(start.Add(tt.at))
+		if allow != tt.allow || wait != tt.wait {
+			t.Errorf("%s: allow = %t, %s, want %t, %s", tt.name, allow, wait, tt.allow, tt.wait)
+		}
+	}
+}
+
+func TestSyncHandlerRateLimit(t *testing.T) {
+	newTestDB(t)
+	useTestFeed(t, testFeed)
+	saved := manualSyncLimiter
+	manualSyncLimiter = &syncLimiter{interval: time.Minute}
+	t.Cleanup(func() { manualSyncLimiter = saved })
+
+	// Steps run in order, sharing the limiter
+	steps := []struct {
+		name       string
+		method     string
+		busy       bool // another sync holds the slot
+		status     int
+		retryAfter string
+	}{
+		{"rejected while another sync runs", "POST", true, http.StatusConflict, ""},
+		{"wrong method", "GET", false, http.StatusMethodNotAllowed, ""},
+		{"first sync", "POST", false, http.StatusOK, ""},
+		{"second rapid request is throttled", "POST", false, http.StatusTooManyRequests, "60"},
+	}
+	for _, step := range steps {
+		if step.busy && !tryStartSync() {
+			t.Fatal("sync slot already taken")
+		}
+		rec := serve(syncHandler, httptest.NewRequest(step.method, "/sync?wait=true", nil))
+		if step.busy {
+			finishSync()
+		}
+		if rec.Code != step.status {
+			t.Fatalf("%s: status = %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+		if got := rec.Header().Get("Retry-After"); got != step.retryAfter {
+			t.Errorf("%s: Retry-After = %q, want %q", step.name, got, step.retryAfter)
+		}
+	}
+	if !tryStartSync() {
+		t.Fatal("throttled request kept the sync slot")
+	}
+	finishSync()
+}
+
+func TestHomeHandlerContentNegotiation(t *testing.T) {
+	newTestDB(t)
+	articles := seedArticles(t, testArticle(1, "Unread story"), testArticle(2, "Read story"))
+	if _, err := store.MarkRead(t.Context(), articles[1].ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		accept string
+		json   bool
+		titles []string // listed articles, for JSON responses
+	}{
+		{"html by default", "/", "", false, nil},
+		{"format param", "/?format=json", "", true, []string{"Unread story"}},
+		{"accept header", "/", "application/json", true, []string{"Unread story"}},
+		{"browser accept header", "/", "text/html,application/xhtml+xml,application/json;q=0.9", false, nil},
+		{"format param wins", "/?format=html", "application/json", false, nil},
+		{"filters apply", "/?format=json&show=read", "", true, []string{"Read story"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := serve(homeHandler, req)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			contentType := rec.Header().Get("Content-Type")
+			if !tt.json {
+				if !strings.HasPrefix(contentType, "text/html") {
+					t.Errorf("Content-Type = %q, want HTML", contentType)
+				}
+				return
+			}
+			if contentType != "application/json" {
+				t.Fatalf("Content-Type = %q, want application/json", contentType)
+			}
+			var page ArticlePage
+			decodeJSON(t, rec, &page)
+			if titles := articleTitles(page.Articles); !slices.Equal(titles, tt.titles) {
+				t.Errorf("titles = %q, want %q", titles, tt.titles)
+			}
+			if page.UnreadCount != 1 {
+				t.Errorf("unread count = %d, want 1", page.UnreadCount)
+			}
+		})
+	}
+}
+
+func TestBulkMarkReadHandler(t *testing.T) {
+	newTestDB(t)
+	articles := seedArticles(t, testArticle(1, "One"), testArticle(2, "Two"), testArticle(3, "Three"))
+	one, two := articles[0].ID, articles[1].ID
+
+	tests := []struct {
+		name    string
+		body    string
+		status  int
+		updated int
+	}{
+		{"marks read", fmt.Sprintf(`{"ids": [%d, %d, 9999], "read": true}`, one, two), http.StatusOK, 2},
+		{"repeated ids count once", fmt.Sprintf(`{"ids": [%d, %d], "read": false}`, one, one), http.StatusOK, 1},
+		{"empty list", `{"ids": [], "read": true}`, http.StatusBadRequest, 0},
+		{"id not an integer", fmt.Sprintf(`{"ids": [%d, "2"], "read": true}`, one), http.StatusBadRequest, 0},
+		{"id not positive", `{"ids": [0], "read": true}`, http.StatusBadRequest, 0},
+		{"missing read", fmt.Sprintf(`{"ids": [%d]}`, one), http.StatusBadRequest, 0},
+		{"not json", `ids=1`, http.StatusBadRequest, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(bulkMarkReadHandler, httptest.NewRequest("POST", "/mark-read/bulk", strings.NewReader(tt.body)))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			var result struct {
+				Updated int `json:"updated"`
+			}
+			decodeJSON(t, rec, &result)
+			if result.Updated != tt.updated {
+				t.Errorf("updated = %d, want %d", result.Updated, tt.updated)
+			}
+		})
+	}
+
+	// After both successful requests only the second article is still read
+	if n, err := store.UnreadCount(t.Context()); err != nil || n != 2 {
+		t.Errorf("unread count = %d, %v, want 2", n, err)
+	}
+}
+
+func TestVersionHandler(t *testing.T) {
+	savedVersion, savedCommit, savedDate := version, commit, buildDate
+	t.Cleanup(func() { version, commit, buildDate = savedVersion, savedCommit, savedDate })
+
+	tests := []struct {
+		name string
+		want VersionInfo
+	}{
+		{"defaults", VersionInfo{Version: "dev", Commit: "unknown", BuildDate: "unknown"}},
+		{"set by ldflags", VersionInfo{Version: "v1.2.3", Commit: "abc123", BuildDate: "2024-01-02T03:04:05Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, commit, buildDate = tt.want.Version, tt.want.Commit, tt.want.BuildDate
+			rec := serve(versionHandler, httptest.NewRequest("GET", "/version", nil))
+			var got VersionInfo
+			decodeJSON(t, rec, &got)
+			if got != tt.want {
+				t.Errorf("version = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelativeFeedLinks(t *testing.T) {
+	feedURL, _ := url.Parse("https://news.ycombinator.com/rss")
+
+	tests := []struct {
+		name     string
+		link     string
+		comments string
+		article  string
+		comment  string
+	}{
+		{"absolute", "https://example.com/a", "https://news.ycombinator.com/item?id=1", "https://example.com/a", "https://news.ycombinator.com/item?id=1"},
+		{"root relative", "/from?site=example.com", "/item?id=2", "https://news.ycombinator.com/from?site=example.com", "https://news.ycombinator.com/item?id=2"},
+		{"path relative", "story", "item?id=3", "https://news.ycombinator.com/story", "https://news.ycombinator.com/item?id=3"},
+		{"scheme relative", "//example.com/b", "//news.ycombinator.com/item?id=4", "https://example.com/b", "https://news.ycombinator.com/item?id=4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			articles := rssItemParser{feedURL: feedURL}.Parse(Item{Title: "A story", Link: tt.link, Comments: tt.comments}, "")
+			if len(articles) != 1 {
+				t.Fatalf("parsed %d articles, want 1", len(articles))
+			}
+			if articles[0].ArticleLink != tt.article || articles[0].CommentLink != tt.comment {
+				t.Errorf("links = %q, %q, want %q, %q", articles[0].ArticleLink, articles[0].CommentLink, tt.article, tt.comment)
+			}
+		})
+	}
+
+	t.Run("hn daily resolves against the item link", func(t *testing.T) {
+		daily, _ := url.Parse("https://www.daemonology.net/hn-daily/index.rss")
+		item := Item{
+			Link: "https://www.daemonology.net/hn-daily/2024-01-01.html",
+			Description: `<ul><li><span class="storylink"><a href="../story">A story</a></span>` +
+				`<br><span class="postlink"><a href="/item?id=5">comments</a></span></li></ul>`,
+		}
+		articles := daemonologyParser{feedURL: daily}.Parse(item, "")
+		if len(articles) != 1 {
+			t.Fatalf("parsed %d articles, want 1", len(articles))
+		}
+		if want := "https://www.daemonology.net/story"; articles[0].ArticleLink != want {
+			t.Errorf("article link = %q, want %q", articles[0].ArticleLink, want)
+		}
+		if want := "https://www.daemonology.net/item?id=5"; articles[0].CommentLink != want {
+			t.Errorf("comment link = %q, want %q", articles[0].CommentLink, want)
+		}
+	})
+}
+
+func TestSyncTimeout(t *testing.T) {
+	newTestDB(t)
+	savedTimeout := syncTimeout
+	syncTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { syncTimeout = savedTimeout })
+
+	// Answers after delay, or gives up when the client does
+	slowFeed := func(delay time.Duration) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(delay):
+				w.Header().Set("Content-Type", "application/rss+xml")
+				io.WriteString(w, testFeed)
+			case <-r.Context().Done():
+			}
+		}))
+		t.Cleanup(srv.Close)
+		return srv.URL
+	}
+
+	tests := []struct {
+		name    string
+		delay   time.Duration
+		status  int
+		outcome string
+	}{
+		{"fast feed", 0, http.StatusOK, "complete"},
+		{"feed slower than the timeout", time.Second, http.StatusGatewayTimeout, "timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := feedURLs
+			feedURLs = []string{slowFeed(tt.delay)}
+			defer func() { feedURLs = saved }()
+
+			if !tryStartSync() {
+				t.Fatal("sync slot already taken")
+			}
+			rec := httptest.NewRecorder()
+			func() {
+				defer finishSync()
+				syncAndWait(rec, httptest.NewRequest("POST", "/sync?wait=true", nil))
+			}()
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			var result SyncResult
+			decodeJSON(t, rec, &result)
+			if result.Status != tt.outcome {
+				t.Errorf("outcome = %q, want %q", result.Status, tt.outcome)
+			}
+		})
+	}
+}
+
+func TestMarkReadIsIdempotent(t *testing.T) {
+	newTestDB(t)
+	id := strconv.Itoa(seedArticles(t, testArticle(1, "Retried"))[0].ID)
+	firstRead := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	steps := []struct {
+		name   string
+		read   string
+		want   bool
+		readAt *time.Time // nil when read_at should be null, zero when any time will do
+	}{
+		{"mark read", "true", true, &time.Time{}},
+		{"retry keeps the first read time", "true", true, &firstRead},
+		{"mark unread", "false", false, nil},
+		{"retry unread", "false", false, nil},
+	}
+	for i, step := range steps {
+		rec := serve(markReadHandler, postForm("/mark-read", url.Values{"id": {id}, "read": {step.read}}))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", step.name, rec.Code, rec.Body)
+		}
+		var result MarkReadResult
+		decodeJSON(t, rec, &result)
+		if result.Read != step.want {
+			t.Errorf("%s: read = %t, want %t", step.name, result.Read, step.want)
+		}
+		switch {
+		case step.readAt == nil && result.ReadAt != nil:
+			t.Errorf("%s: read_at = %s, want null", step.name, result.ReadAt)
+		case step.readAt != nil && result.ReadAt == nil:
+			t.Errorf("%s: read_at is null", step.name)
+		case step.readAt != nil && !step.readAt.IsZero() && !result.ReadAt.Equal(*step.readAt):
+			t.Errorf("%s: read_at = %s, want %s", step.name, result.ReadAt, step.readAt)
+		}
+
+		// Backdate the first read so a retry that reset it would be noticed
+		if i == 0 {
+			if _, err := db.Exec(`UPDATE articles SET read_at = ? WHERE id = ?`, firstRead.Format(sqliteTimeFormat), id); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestSyncStatusHandler(t *testing.T) {
+	newTestDB(t)
+	feedURL := useTestFeed(t, testFeed)
+	syncStatusMu.Lock()
+	lastSyncStatus = SyncStatus{}
+	syncStatusMu.Unlock()
+	syncTimeMu.Lock()
+	lastSyncTime = time.Time{}
+	syncTimeMu.Unlock()
+
+	status := func() SyncStatus {
+		var s SyncStatus
+		decodeJSON(t, serve(syncStatusHandler, httptest.NewRequest("GET", "/sync/status", nil)), &s)
+		return s
+	}
+
+	if s := status(); s.LastStarted != nil || s.LastSuccess != nil || s.Outcome != "" {
+		t.Errorf("before any sync: %+v, want an empty status", s)
+	}
+
+	if _, err := processFeed(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	ok := status()
+	if ok.Outcome != "complete" || ok.NewArticles != 2 || ok.Error != "" || ok.LastSuccess == nil || ok.Duration == "" {
+		t.Errorf("after a good sync: %+v", ok)
+	}
+
+	feedURLs = []string{feedURL + ".missing"}
+	if _, err := processFeed(t.Context()); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+	failed := status()
+	if failed.Outcome != "completed with errors" || failed.NewArticles != 0 || failed.Error == "" {
+		t.Errorf("after a failed sync: %+v", failed)
+	}
+	// A sync where every feed failed isn't a success
+	if failed.LastSuccess == nil || !failed.LastSuccess.Equal(*ok.LastSuccess) {
+		t.Errorf("last success = %v, want %v", failed.LastSuccess, ok.LastSuccess)
+	}
+
+	if !tryStartSync() {
+		t.Fatal("sync slot already taken")
+	}
+	defer finishSync()
+	if s := status(); !s.Running {
+		t.Error("running = false while a sync holds the slot")
+	}
+}
+
+func TestSQLiteWALAndBusyTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		busy    time.Duration
+		wantMS  int
+		wantErr bool
+	}{
+		{"default", 5 * time.Second, 5000, false},
+		{"configured", 250 * time.Millisecond, 250, false},
+		{"negative", -time.Second, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.DBPath = filepath.Join(t.TempDir(), "hn_reader.db")
+			cfg.SQLiteBusy = jsonDuration{tt.busy}
+			if err := cfg.validate(); (err != nil) != tt.wantErr {
+				t.Fatalf("validate: %v, want error: %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if err := initDB(cfg); err != nil {
+				t.Fatalf("initDB: %v", err)
+			}
+			defer db.Close()
+
+			var mode string
+			var busyMS int
+			if err := db.QueryRow(`PRAGMA journal_mode`).Scan(&mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.QueryRow(`PRAGMA busy_timeout`).Scan(&busyMS); err != nil {
+				t.Fatal(err)
+			}
+			if mode != "wal" || busyMS != tt.wantMS {
+				t.Errorf("journal_mode = %s, busy_timeout = %d, want wal and %d", mode, busyMS, tt.wantMS)
+			}
+		})
+	}
+}
+
+func TestReadLaterQueue(t *testing.T) {
+	newTestDB(t)
+	articles := seedArticles(t, testArticle(1, "Now"), testArticle(2, "Later"))
+	later := strconv.Itoa(articles[1].ID)
+
+	post := func(handler http.HandlerFunc, action string) {
+		t.Helper()
+		req := withPathValues(httptest.NewRequest("POST", "/articles/"+later+"/"+action, nil), "id", later)
+		if rec := serve(handler, req); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d: %s", action, rec.Code, rec.Body)
+		}
+	}
+
+	steps := []struct {
+		name   string
+		action func()
+		unread []string
+		queue  []string
+	}{
+		{"new articles are unread", func() {}, []string{"Later", "Now"}, []string{}},
+		{"moved to later", func() { post(laterArticleHandler, "later") }, []string{"Now"}, []string{"Later"}},
+		{"marking read leaves the queue", func() { post(setArticleReadHandler(true), "read") }, []string{"Now"}, []string{}},
+		{"moved back to later", func() { post(laterArticleHandler, "later") }, []string{"Now"}, []string{"Later"}},
+		{"marking unread returns it to the list", func() { post(setArticleReadHandler(false), "unread") }, []string{"Later", "Now"}, []string{}},
+	}
+	for _, step := range steps {
+		step.action()
+		if got := listTitles(t, apiArticlesHandler, "/api/articles"); !slices.Equal(got, step.unread) {
+			t.Errorf("%s: unread = %q, want %q", step.name, got, step.unread)
+		}
+		if got := listTitles(t, laterHandler, "/later"); !slices.Equal(got, step.queue) {
+			t.Errorf("%s: later = %q, want %q", step.name, got, step.queue)
+		}
+	}
+
+	if rec := serve(laterArticleHandler, withPathValues(httptest.NewRequest("POST", "/articles/9999/later", nil), "id", "9999")); rec.Code != http.StatusNotFound {
+		t.Errorf("missing article: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestMaxFeedBytes(t *testing.T) {
+	saved := maxFeedBytes
+	t.Cleanup(func() { maxFeedBytes = saved })
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		io.WriteString(w, testFeed)
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "feed.rss")
+	if err := os.WriteFile(path, []byte(testFeed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	size := int64(len(testFeed))
+	tests := []struct {
+		name    string
+		limit   int64
+		wantErr bool
+	}{
+		{"under the limit", size + 1, false},
+		{"exactly at the limit", size, false},
+		{"over the limit", size - 1, true},
+	}
+	for _, tt := range tests {
+		for _, feedURL := range []string{srv.URL, "file://" + path} {
+			t.Run(tt.name+" "+feedURL[:4], func(t *testing.T) {
+				maxFeedBytes = tt.limit
+				_, err := fetchAndParseRSS(t.Context(), feedURL, true)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("err = %v, want error: %t", err, tt.wantErr)
+				}
+				if err != nil && !strings.Contains(err.Error(), "MAX_FEED_BYTES") {
+					t.Errorf("error %q doesn't mention MAX_FEED_BYTES", err)
+				}
+			})
+		}
+	}
+}
+
+func TestTemplateFailures(t *testing.T) {
+	newTestDB(t)
+	savedAssets, savedDev := assets, devMode
+	t.Cleanup(func() {
+		assets, devMode = savedAssets, savedDev
+		if err := loadTemplates(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	useTemplate := func(body string) error {
+		assets = fstest.MapFS{"templates/home.html": {Data: []byte(body)}}
+		return loadTemplates()
+	}
+
+	t.Run("execution error is a clean 500", func(t *testing.T) {
+		devMode = false
+		if err := useTemplate(`<html><h1>{{.Title}}</h1>{{.NoSuchField}}</html>`); err != nil {
+			t.Fatal(err)
+		}
+		rec := serve(homeHandler, httptest.NewRequest("GET", "/?page=1", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", rec.Code)
+		}
+		if strings.Contains(rec.Body.String(), "<h1>") {
+			t.Errorf("response includes part of the page: %q", rec.Body)
+		}
+	})
+
+	t.Run("dev reload keeps the last good templates", func(t *testing.T) {
+		devMode = false
+		if err := useTemplate(`<p>{{.Title}}</p>`); err != nil {
+			t.Fatal(err)
+		}
+		devMode = true
+		tests := []struct {
+			name     string
+			template string
+			want     string
+		}{
+			{"edit is picked up", `<p>edited {{.Title}}</p>`, "<p>edited HN Reader</p>"},
+			{"syntax error keeps the previous version", `<p>{{if}}</p>`, "<p>edited HN Reader</p>"},
+		}
+		for _, tt := range tests {
+			assets = fstest.MapFS{"templates/home.html": {Data: []byte(tt.template)}}
+			body, err := renderTemplate("home.html", TemplateData{Title: "HN Reader"})
+			if err != nil || string(body) != tt.want {
+				t.Errorf("%s: rendered %q, %v, want %q", tt.name, body, err, tt.want)
+			}
+		}
+	})
+}
+
+func TestSyncHistoryHandler(t *testing.T) {
+	newTestDB(t)
+	feedURL := useTestFeed(t, testFeed)
+	if _, err := processFeed(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	feedURLs = []string{feedURL + ".missing"}
+	if _, err := processFeed(t.Context()); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+
+	// Runs are newest first, as {success, new articles}
+	type run struct {
+		success     bool
+		newArticles int
+	}
+	tests := []struct {
+		name   string
+		query  string
+		status int
+		runs   []run
+	}{
+		{"default limit", "", http.StatusOK, []run{{false, 0}, {true, 2}}},
+		{"limit", "?limit=1", http.StatusOK, []run{{false, 0}}},
+		{"zero", "?limit=0", http.StatusBadRequest, nil},
+		{"too many", "?limit=1001", http.StatusBadRequest, nil},
+		{"not a number", "?limit=ten", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(syncHistoryHandler, httptest.NewRequest("GET", "/api/sync-history"+tt.query, nil))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.runs == nil {
+				return
+			}
+			var runs []SyncRun
+			decodeJSON(t, rec, &runs)
+			var got []run
+			for _, r := range runs {
+				got = append(got, run{r.Success, r.NewArticles})
+			}
+			if !slices.Equal(got, tt.runs) {
+				t.Errorf("runs = %+v, want %+v", got, tt.runs)
+			}
+		})
+	}
+}
+
+func TestDeleteArticleHandler(t *testing.T) {
+	newTestDB(t)
+	articles := seedArticles(t, testArticle(1, "Garbage"), testArticle(2, "Keeper"))
+	id := strconv.Itoa(articles[0].ID)
+	if err := store.AddTag(t.Context(), articles[0].ID, "junk"); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		id     string
+		status int
+	}{
+		{"existing article", id, http.StatusOK},
+		{"already deleted", id, http.StatusNotFound},
+		{"invalid id", "abc", http.StatusBadRequest},
+	}
+	for _, step := range steps {
+		req := withPathValues(httptest.NewRequest("DELETE", "/articles/"+step.id, nil), "id", step.id)
+		if rec := serve(deleteArticleHandler, req); rec.Code != step.status {
+			t.Errorf("%s: status = %d, want %d: %s", step.name, rec.Code, step.status, rec.Body)
+		}
+	}
+
+	if got := listTitles(t, apiArticlesHandler, "/api/articles?read=all"); !slices.Equal(got, []string{"Keeper"}) {
+		t.Errorf("articles left = %q, want only Keeper", got)
+	}
+	var tags int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM article_tags`).Scan(&tags); err != nil || tags != 0 {
+		t.Errorf("article_tags rows = %d, %v, want the deleted article's tags gone", tags, err)
+	}
+}
+
+func TestAPIArticlesETag(t *testing.T) {
+	newTestDB(t)
+	article := seedArticles(t, testArticle(1, "Polled"))[0]
+
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/articles", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(apiArticlesHandler, req)
+	}
+	etag := get("").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"no validator", "", http.StatusOK},
+		{"matching", etag, http.StatusNotModified},
+		{"weak match", "W/" + etag, http.StatusNotModified},
+		{"one of several", `"stale", ` + etag, http.StatusNotModified},
+		{"different", `"stale"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := get(tt.ifNoneMatch)
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if rec.Code == http.StatusNotModified && rec.Body.Len() > 0 {
+				t.Errorf("304 has a body: %q", rec.Body)
+			}
+		})
+	}
+
+	if _, err := store.MarkStarred(t.Context(), article.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("after starring: status %d, ETag %q, want a new listing", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestRawItems(t *testing.T) {
+	// Two stories and an item nothing can be parsed from
+	feed := strings.Replace(testFeed, "</channel>", "<item><description>Not a story</description></item></channel>", 1)
+	count := func(query string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(query).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	t.Run("stored once and pruned", func(t *testing.T) {
+		newTestDB(t)
+		useTestFeed(t, feed)
+
+		steps := []struct {
+			name   string
+			action func() error
+			items  int
+			linked int // articles pointing at a raw item
+		}{
+			{"sync", func() error { _, err := processFeed(t.Context()); return err }, 3, 2},
+			{"sync again", func() error { _, err := processFeed(t.Context()); return err }, 3, 2},
+			{"prune", func() error { _, err := store.PruneRawItems(t.Context(), time.Now().Add(time.Hour)); return err }, 0, 0},
+		}
+		for _, step := range steps {
+			if err := step.action(); err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if n := count(`SELECT COUNT(*) FROM raw_items`); n != step.items {
+				t.Errorf("%s: raw items = %d, want %d", step.name, n, step.items)
+			}
+			if n := count(`SELECT COUNT(*) FROM articles WHERE raw_item_id IS NOT NULL`); n != step.linked {
+				t.Errorf("%s: linked articles = %d, want %d", step.name, n, step.linked)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		newTestDB(t)
+		useTestFeed(t, feed)
+		storeRawItems = false
+		t.Cleanup(func() { storeRawItems = true })
+
+		if _, err := processFeed(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		if n := count(`SELECT COUNT(*) FROM raw_items`); n != 0 {
+			t.Errorf("raw items = %d, want none with STORE_RAW_ITEMS=false", n)
+		}
+	})
+}
+
+func TestHealthAndReady(t *testing.T) {
+	tests := []struct {
+		name        string
+		setup       func(t *testing.T)
+		ready       int
+		database    string
+		templatesOK bool
+	}{
+		{"ready", func(t *testing.T) {}, http.StatusOK, "ok", true},
+		{"database down", func(t *testing.T) { db.Close() }, http.StatusServiceUnavailable, "unavailable", true},
+		{"templates missing", func(t *testing.T) {
+			templatesMu.Lock()
+			saved := templates
+			templates = nil
+			templatesMu.Unlock()
+			t.Cleanup(func() {
+				templatesMu.Lock()
+				templates = saved
+				templatesMu.Unlock()
+			})
+		}, http.StatusServiceUnavailable, "ok", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDB(t)
+			tt.setup(t)
+
+			// Liveness never depends on the database or templates
+			if rec := serve(healthHandler, httptest.NewRequest("GET", "/health", nil)); rec.Code != http.StatusOK {
+				t.Errorf("/health status = %d, want 200", rec.Code)
+			}
+
+			rec := serve(readyHandler, httptest.NewRequest("GET", "/ready", nil))
+			if rec.Code != tt.ready {
+				t.Errorf("/ready status = %d, want %d: %s", rec.Code, tt.ready, rec.Body)
+			}
+			var status ReadyStatus
+			decodeJSON(t, rec, &status)
+			if status.Database != tt.database || (status.Templates == "ok") != tt.templatesOK {
+				t.Errorf("/ready = %+v, want database %s, templates ok: %t", status, tt.database, tt.templatesOK)
+			}
+		})
+	}
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	tests := []struct {
+		name      string
+		origins   []string
+		method    string
+		path      string
+		origin    string
+		preflight bool
+		status    int
+		allow     string // Access-Control-Allow-Origin
+	}{
+		{"allowed origin", []string{"https://app.example.com/"}, "GET", "/api/articles", "https://app.example.com", false, http.StatusOK, "https://app.example.com"},
+		{"origin case is ignored", []string{"https://App.Example.com"}, "GET", "/api/articles", "https://app.example.com", false, http.StatusOK, "https://app.example.com"},
+		{"preflight", []string{"https://app.example.com"}, "OPTIONS", "/api/articles", "https://app.example.com", true, http.StatusNoContent, "https://app.example.com"},
+		{"any origin", []string{"*"}, "GET", "/api/articles", "https://elsewhere.example.com", false, http.StatusOK, "*"},
+		{"other origin", []string{"https://app.example.com"}, "GET", "/api/articles", "https://evil.example.com", false, http.StatusOK, ""},
+		{"not the api", []string{"*"}, "GET", "/mark-read", "https://app.example.com", false, http.StatusOK, ""},
+		{"same origin request", []string{"*"}, "GET", "/api/articles", "", false, http.StatusOK, ""},
+		{"unset", nil, "GET", "/api/articles", "https://app.example.com", false, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				req.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			rec := httptest.NewRecorder()
+			corsMiddleware(tt.origins, next).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.allow)
+			}
+			if got := rec.Header().Get("Access-Control-Allow-Methods"); (got != "") != tt.preflight {
+				t.Errorf("Access-Control-Allow-Methods = %q, want it only on preflights", got)
+			}
+		})
+	}
+}
+
+func TestSnooze(t *testing.T) {
+	newTestDB(t)
+	articles := seedArticles(t, testArticle(1, "Awake"), testArticle(2, "Sleepy"))
+	id := strconv.Itoa(articles[1].ID)
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format(dateParamLayout)
+
+	snooze := func(until string) func() int {
+		return func() int {
+			req := httptest.NewRequest("POST", "/articles/"+id+"/snooze?until="+url.QueryEscape(until), nil)
+			return serve(snoozeArticleHandler, withPathValues(req, "id", id)).Code
+		}
+	}
+	unsnooze := func() int {
+		req := httptest.NewRequest("POST", "/articles/"+id+"/unsnooze", nil)
+		return serve(unsnoozeArticleHandler, withPathValues(req, "id", id)).Code
+	}
+	// Moves the snooze into the past, as if time had passed
+	expire := func() int {
+		past := time.Now().Add(-time.Minute).UTC().Format(sqliteTimeFormat)
+		if _, err := db.Exec(`UPDATE articles SET snoozed_until = ? WHERE id = ?`, past, id); err != nil {
+			t.Fatal(err)
+		}
+		return http.StatusOK
+	}
+
+	steps := []struct {
+		name    string
+		action  func() int
+		status  int
+		unread  []string
+		snoozed []string
+	}{
+		{"missing until", snooze(""), http.StatusBadRequest, []string{"Sleepy", "Awake"}, []string{}},
+		{"malformed until", snooze("next week"), http.StatusBadRequest, []string{"Sleepy", "Awake"}, []string{}},
+		{"until in the past", snooze("2020-01-01"), http.StatusBadRequest, []string{"Sleepy", "Awake"}, []string{}},
+		{"snoozed", snooze(tomorrow), http.StatusOK, []string{"Awake"}, []string{"Sleepy"}},
+		{"unsnoozed", unsnooze, http.StatusOK, []string{"Sleepy", "Awake"}, []string{}},
+		{"snoozed again", snooze(time.Now().Add(time.Hour).Format(time.RFC3339)), http.StatusOK, []string{"Awake"}, []string{"Sleepy"}},
+		{"snooze runs out", expire, http.StatusOK, []string{"Sleepy", "Awake"}, []string{}},
+	}
+	for _, step := range steps {
+		if status := step.action(); status != step.status {
+			t.Fatalf("%s: status = %d, want %d", step.name, status, step.status)
+		}
+		if got := listTitles(t, apiArticlesHandler, "/api/articles"); !slices.Equal(got, step.unread) {
+			t.Errorf("%s: unread = %q, want %q", step.name, got, step.unread)
+		}
+		if got := listTitles(t, apiArticlesHandler, "/api/articles?read=snoozed"); !slices.Equal(got, step.snoozed) {
+			t.Errorf("%s: snoozed = %q, want %q", step.name, got, step.snoozed)
+		}
+	}
+}
+
+func TestJSONFeedHandler(t *testing.T) {
+	newTestDB(t)
+	read := testArticle(1, "Already read")
+	read.Read = true
+	unread := testArticle(2, "Fresh")
+	unread.CreatedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	noComments := testArticle(3, "Quiet")
+	noComments.CreatedAt = unread.CreatedAt.Add(time.Hour)
+	noComments.CommentLink = ""
+	seedArticles(t, read, unread, noComments)
+
+	rec := serve(jsonFeedHandler, httptest.NewRequest("GET", "http://reader.example.com/feed.json", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/feed+json") {
+		t.Errorf("Content-Type = %q, want application/feed+json", ct)
+	}
+	var feed JSONFeed
+	decodeJSON(t, rec, &feed)
+	if feed.Version != "https://jsonfeed.org/version/1.1" || feed.FeedURL != "http://reader.example.com/feed.json" {
+		t.Errorf("feed = %q, %q", feed.Version, feed.FeedURL)
+	}
+
+	want := []JSONFeedItem{
+		{ID: "https://example.com/Quiet", URL: "https://example.com/Quiet", Title: "Quiet", DatePublished: "2024-01-02T04:04:05Z"},
+		{ID: unread.CommentLink, URL: unread.ArticleLink, Title: "Fresh", DatePublished: "2024-01-02T03:04:05Z"},
+	}
+	if len(feed.Items) != len(want) {
+		t.Fatalf("items = %+v, want %d unread", feed.Items, len(want))
+	}
+	for i, item := range feed.Items {
+		item.ContentHTML = ""
+		if item != want[i] {
+			t.Errorf("item %d = %+v, want %+v", i, item, want[i])
+		}
+	}
+}
+
+func TestParsePagination(t *testing.T) {
+	tests := []struct {
+		query   string
+		page    int
+		perPage int
+		wantErr bool
+	}{
+		{"", 1, defaultPerPage, false},
+		{"page=3&per_page=20", 3, 20, false},
+		{"page=0", 1, defaultPerPage, false},
+		{"page=-5", 1, defaultPerPage, false},
+		{"page=100000", maxPage, defaultPerPage, false},
+		{"page=100001", maxPage, defaultPerPage, false},
+		{"per_page=0", 1, defaultPerPage, false},
+		{"per_page=-1", 1, defaultPerPage, false},
+		{"per_page=1", 1, 1, false},
+		{"per_page=200", 1, maxPerPage, false},
+		{"per_page=201", 1, maxPerPage, false},
+		{"per_page=100000", 1, maxPerPage, false},
+		{"page=two", 0, 0, true},
+		{"per_page=1.5", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page, perPage, err := parsePagination(httptest.NewRequest("GET", "/?"+tt.query, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error: %t", err, tt.wantErr)
+			}
+			if page != tt.page || perPage != tt.perPage {
+				t.Errorf("page, per_page = %d, %d, want %d, %d", page, perPage, tt.page, tt.perPage)
+			}
+		})
+	}
+}
+
+func TestPaginationInHandlers(t *testing.T) {
+	newTestDB(t)
+	seedArticles(t, testArticle(1, "One"), testArticle(2, "Two"), testArticle(3, "Three"))
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		target  string
+		status  int
+	}{
+		{"api page", apiArticlesHandler, "/api/articles?page=2&per_page=2", http.StatusOK},
+		{"api rejects non-numbers", apiArticlesHandler, "/api/articles?per_page=lots", http.StatusBadRequest},
+		{"home rejects non-numbers", homeHandler, "/?format=json&page=last", http.StatusBadRequest},
+		{"html home rejects non-numbers", homeHandler, "/?page=last", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := serve(tt.handler, httptest.NewRequest("GET", tt.target, nil)); rec.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	// Oversized pages are clamped and pages past the end show the last one
+	var page ArticlePage
+	decodeJSON(t, serve(homeHandler, httptest.NewRequest("GET", "/?format=json&per_page=2&page=99", nil)), &page)
+	if page.Page != 2 || page.TotalPages != 2 || len(page.Articles) != 1 {
+		t.Errorf("page past the end = page %d of %d with %d articles, want the last page", page.Page, page.TotalPages, len(page.Articles))
+	}
+	decodeJSON(t, serve(homeHandler, httptest.NewRequest("GET", "/?format=json&per_page=100000", nil)), &page)
+	if page.PerPage != maxPerPage {
+		t.Errorf("per_page = %d, want it clamped to %d", page.PerPage, maxPerPage)
+	}
+}
+
+func TestValidateFeedURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{"https://www.daemonology.net/hn-daily/index.rss", false},
+		{"http://localhost:8000/feed.rss", false},
+		{"file:///var/feeds/saved.rss", false},
+		{"file://localhost/var/feeds/saved.rss", false},
+		{"file://feeds/saved.rss", true},
+		{"file:saved.rss", true},
+		{"ftp://example.com/feed.rss", true},
+		{"https:///feed.rss", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if err := validateFeedURL(tt.url); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error: %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSyncFromFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		missing bool
+		titles  []string
+	}{
+		{"saved feed", false, []string{"First story", "Second story"}},
+		{"missing file", true, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDB(t)
+			feedURL := useTestFeed(t, testFeed)
+			if tt.missing {
+				feedURLs = []string{feedURL + ".missing"}
+			}
+
+			stats, err := processFeed(t.Context())
+			if (err != nil) != tt.missing {
+				t.Fatalf("err = %v, want error: %t", err, tt.missing)
+			}
+			if stats.NewArticles != len(tt.titles) {
+				t.Errorf("new articles = %d, want %d", stats.NewArticles, len(tt.titles))
+			}
+			if got := listTitles(t, apiArticlesHandler, "/api/articles"); !slices.Equal(got, tt.titles) {
+				t.Errorf("articles = %q, want %q", got, tt.titles)
+			}
+		})
+	}
+}
+
+func TestHideSeen(t *testing.T) {
+	tests := []struct {
+		mode      string
+		wantSaved bool
+		wantRead  bool
+	}{
+		{hideSeenOff, true, false},
+		{hideSeenRead, true, true},
+		{hideSeenSkip, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			newTestDB(t)
+			old := hideSeen
+			hideSeen = tt.mode
+			t.Cleanup(func() { hideSeen = old })
+
+			original := testArticle(10, "Ben & Jerry's Story (2019)")
+			original.Read = true
+			repost := testArticle(20, "ben jerrys story")
+			unrelated := testArticle(30, "Something else")
+			seedArticles(t, original)
+			seedArticles(t, repost, unrelated)
+
+			var read bool
+			err := db.QueryRow(`SELECT read FROM articles WHERE title = ?`, repost.Title).Scan(&read)
+			if saved := err != sql.ErrNoRows; saved != tt.wantSaved {
+				t.Fatalf("repost saved = %t (err %v), want %t", saved, err, tt.wantSaved)
+			}
+			if err == nil && read != tt.wantRead {
+				t.Errorf("repost read = %t, want %t", read, tt.wantRead)
+			}
+			if err := db.QueryRow(`SELECT read FROM articles WHERE title = ?`, unrelated.Title).Scan(&read); err != nil || read {
+				t.Errorf("unrelated article read = %t, err = %v; want unread", read, err)
+			}
+		})
+	}
+}
+
+func TestSyncStats(t *testing.T) {
+	newTestDB(t)
+	// The middle item has no title, so it can't be parsed into an article
+	useTestFeed(t, strings.Replace(testFeed, "<item><title>Second story</title>",
+		"<item><title></title><link>https://example.com/untitled</link></item><item><title>Second story</title>", 1))
+
+	tests := []struct {
+		name string
+		want SyncStats
+	}{
+		{"first sync", SyncStats{ItemsFetched: 3, ArticlesParsed: 2, NewArticles: 2, ParseErrors: 1}},
+		{"repeat sync", SyncStats{ItemsFetched: 3, ArticlesParsed: 2, SkippedDuplicates: 2, ParseErrors: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := processFeed(t.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("stats = %+v, want %+v", got, tt.want)
+			}
+			var status SyncStatus
+			decodeJSON(t, serve(syncStatusHandler, httptest.NewRequest("GET", "/sync/status", nil)), &status)
+			if status.SyncStats != tt.want {
+				t.Errorf("status stats = %+v, want %+v", status.SyncStats, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextUnreadHandler(t *testing.T) {
+	relative := testArticle(3, "relative")
+	relative.ArticleLink = "/relative"
+	script := testArticle(4, "script")
+	script.ArticleLink = "javascript:alert(1)"
+	script.CommentLink = "javascript:alert(2)"
+
+	tests := []struct {
+		name     string
+		seed     []Article
+		after    func(ids []int) string
+		wantCode int
+		wantLoc  string
+	}{
+		{"no articles", nil, nil, http.StatusFound, "/"},
+		{"oldest unread first", []Article{testArticle(1, "one"), testArticle(2, "two")}, nil,
+			http.StatusFound, "https://example.com/one"},
+		{"after marks read", []Article{testArticle(1, "one"), testArticle(2, "two")},
+			func(ids []int) string { return strconv.Itoa(ids[0]) }, http.StatusFound, "https://example.com/two"},
+		{"last article", []Article{testArticle(1, "one")},
+			func(ids []int) string { return strconv.Itoa(ids[0]) }, http.StatusFound, "/"},
+		{"missing article", []Article{testArticle(1, "one")},
+			func([]int) string { return "999" }, http.StatusFound, "https://example.com/one"},
+		{"relative link uses comments", []Article{relative}, nil,
+			http.StatusFound, "https://news.ycombinator.com/item?id=relative"},
+		{"unsafe links go home", []Article{script}, nil, http.StatusFound, "/"},
+		{"invalid after", []Article{testArticle(1, "one")},
+			func([]int) string { return "abc" }, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestDB(t)
+			var ids []int
+			for _, a := range tt.seed {
+				ids = append(ids, seedArticles(t, a)[0].ID)
+			}
+			target := "/next"
+			if tt.after != nil {
+				target += "?after=" + tt.after(ids)
+			}
+
+			rec := serve(nextUnreadHandler, httptest.NewRequest("GET", target, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLoc {
+				t.Errorf("Location = %q, want %q", loc, tt.wantLoc)
+			}
+		})
+	}
+}
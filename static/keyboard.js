@@ -0,0 +1,95 @@
+// Keyboard shortcuts for the article list:
+//   j / k  select the next / previous article
+//   o      open the selected article
+//   c      open the selected article's comments
+//   r      toggle read on the selected article
+// The unread state is polled from /api/state so the count and favicon badge
+// stay current and changes made elsewhere are noticed without reloading.
+(function() {
+    const pollInterval = 60000;
+    let selected = -1;
+    let etag = null;
+
+    function articles() {
+        return Array.from(document.querySelectorAll('.article'));
+    }
+
+    function select(index) {
+        const list = articles();
+        if (list.length === 0) return;
+        index = Math.max(0, Math.min(index, list.length - 1));
+        if (selected >= 0 && list[selected]) {
+            list[selected].classList.remove('selected');
+        }
+        selected = index;
+        list[selected].classList.add('selected');
+        list[selected].scrollIntoView({ block: 'nearest' });
+    }
+
+    function selectedArticle() {
+        return selected >= 0 ? articles()[selected] : null;
+    }
+
+    function openLink(index) {
+        const article = selectedArticle();
+        if (!article) return;
+        const links = article.querySelectorAll('.article-title a, .article-meta a');
+        if (links[index]) links[index].click();
+    }
+
+    function pollState() {
+        const headers = etag ? { 'If-None-Match': etag } : {};
+        fetch('/api/state', { headers: headers })
+            .then(response => {
+                if (response.status === 304 || !response.ok) return null;
+                etag = response.headers.get('ETag');
+                return response.json();
+            })
+            .then(state => {
+                if (!state) return;
+                document.getElementById('unread-count').textContent = state.unread_count;
+                const badge = document.getElementById('favicon-badge');
+                if (badge) badge.href = '/favicon-badge.svg?unread=' + state.unread_count;
+            })
+            .catch(error => {
+                console.error('Error fetching state:', error);
+            });
+    }
+
+    document.addEventListener('keydown', function(event) {
+        if (event.ctrlKey || event.metaKey || event.altKey) return;
+        const tag = event.target.tagName;
+        if (tag === 'INPUT' || tag === 'TEXTAREA' || event.target.isContentEditable) return;
+
+        switch (event.key) {
+        case 'j':
+            select(selected + 1);
+            break;
+        case 'k':
+            select(selected - 1);
+            break;
+        case 'o':
+            openLink(0);
+            break;
+        case 'c':
+            openLink(1);
+            break;
+        case 'r': {
+            const article = selectedArticle();
+            if (!article) return;
+            const id = article.id.replace('article-', '');
+            toggleRead(id, article.querySelector('.read-button'));
+            setTimeout(pollState, 500);
+            break;
+        }
+        default:
+            return;
+        }
+        event.preventDefault();
+    });
+
+    document.addEventListener('DOMContentLoaded', function() {
+        pollState();
+        setInterval(pollState, pollInterval);
+    });
+})();